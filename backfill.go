@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"ffiiitc/internal/classifier"
+	"ffiiitc/internal/config"
+	"ffiiitc/internal/firefly"
+	"ffiiitc/internal/handlers"
+	"ffiiitc/internal/rules"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/go-pkgz/lgr"
+)
+
+// classify existing transactions with trained models
+// usage: ffiiitc backfill [-start date] [-end date] [-account id] [-uncategorised] [-dry-run] [-rate 2] [-report file.csv]
+func runBackfill(args []string, l *lgr.Logger) error {
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	var opts handlers.BackfillOptions
+	fs.StringVar(&opts.Start, "start", "", "start date of transactions (YYYY-MM-DD)")
+	fs.StringVar(&opts.End, "end", "", "end date of transactions (YYYY-MM-DD)")
+	fs.StringVar(&opts.AccountID, "account", "", "only transactions of account with this id")
+	fs.BoolVar(&opts.Uncategorised, "uncategorised", false, "only classify transactions without category")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "do not update transactions, only report")
+	fs.Float64Var(&opts.Rate, "rate", handlers.DefaultBackfillRate, "max transaction updates per second, 0 for no limit")
+	reportFile := fs.String("report", "", "csv report file, '-' for stdout (default for dry run)")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.NewConfig(l)
+	if err != nil {
+		return err
+	}
+	cls, err := classifier.NewClassifierFromFile(config.ModelFile, l)
+	if err != nil {
+		return fmt.Errorf("loading model, train it first: %w", err)
+	}
+	fc := firefly.NewFireFlyHttpClient(cfg.FFApp, cfg.APIKey, config.FireflyAppTimeout, l)
+	h := handlers.NewWebHookHandler(cls, fc, cfg, l)
+
+	// optional models are used if they were trained
+	if cfg.RulesPath != "" {
+		h.Rules, err = rules.LoadFile(cfg.RulesPath)
+		if err != nil {
+			return fmt.Errorf("loading rules: %w", err)
+		}
+	}
+	if cfg.TagsEnabled {
+		tagCls, err := classifier.NewTagClassifierFromFile(config.TagModelFile, l)
+		if err != nil {
+			l.Logf("WARN tags are not predicted: %v", err)
+		} else {
+			h.SwapTagClassifier(tagCls)
+		}
+	}
+	if cfg.BudgetsEnabled {
+		budgetCls, err := classifier.NewClassifierFromFile(config.BudgetModelFile, l)
+		if err != nil {
+			l.Logf("WARN budgets are not predicted: %v", err)
+		} else {
+			h.SwapBudgetClassifier(budgetCls)
+		}
+	}
+
+	var report io.Writer
+	if *reportFile == "-" || (*reportFile == "" && opts.DryRun) {
+		report = os.Stdout
+	} else if *reportFile != "" {
+		f, err := os.Create(*reportFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		report = f
+	}
+
+	// interrupted backfill continues on next run with the same filters
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	_, err = h.Backfill(ctx, opts, report)
+	return err
+}
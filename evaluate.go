@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"ffiiitc/internal/classifier"
+	"ffiiitc/internal/config"
+	"ffiiitc/internal/evaluation"
+	"ffiiitc/internal/firefly"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/go-pkgz/lgr"
+)
+
+// evaluate classifier on Firefly transactions and print the report
+// usage: ffiiitc evaluate [-folds 5] [-holdout 0.2] [-format text|json] [-start date] [-end date]
+// [-prior learned|uniform|smoothed] [-max-samples n] [-balance] [-half-life days] [-max-age days]
+func runEvaluate(args []string, l *lgr.Logger) error {
+	fs := flag.NewFlagSet("evaluate", flag.ContinueOnError)
+	folds := fs.Int("folds", 5, "number of folds for stratified k-fold cross-validation")
+	holdout := fs.Float64("holdout", 0, "share of latest transactions to test on, switches to time based holdout")
+	format := fs.String("format", "text", "report format: text or json")
+	seed := fs.Int64("seed", 1, "seed for shuffling transactions into folds")
+	start := fs.String("start", "", "start date of transactions (YYYY-MM-DD)")
+	end := fs.String("end", "", "end date of transactions (YYYY-MM-DD)")
+	// training settings from environment can be overridden to compare them
+	prior := fs.String("prior", "", "prior strategy: learned, uniform or smoothed (default from FF_PRIOR)")
+	maxSamples := fs.Int("max-samples", 0, "max transactions per category, 0 for no cap (default from FF_MAX_CATEGORY_SAMPLES)")
+	balance := fs.Bool("balance", false, "weight transactions inversely to size of their category (default from FF_BALANCE_CATEGORIES)")
+	halfLife := fs.Int("half-life", 0, "half life of transaction weight in days, 0 for no decay (default from FF_HALF_LIFE_DAYS)")
+	maxAge := fs.Int("max-age", 0, "max age of transactions in days, 0 for no limit (default from FF_MAX_AGE_DAYS)")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown report format '%s'", *format)
+	}
+
+	cfg, err := config.NewConfig(l)
+	if err != nil {
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "prior":
+			cfg.Features.Training.Prior = *prior
+		case "max-samples":
+			cfg.Features.Training.MaxSamples = *maxSamples
+		case "balance":
+			cfg.Features.Training.Balance = *balance
+		case "half-life":
+			cfg.Features.Training.HalfLifeDays = *halfLife
+		case "max-age":
+			cfg.Features.Training.MaxAgeDays = *maxAge
+		}
+	})
+	if cfg.Features.Training.Prior != "" && !slices.Contains(classifier.Priors, cfg.Features.Training.Prior) {
+		return fmt.Errorf("unknown prior strategy '%s'", cfg.Features.Training.Prior)
+	}
+	opts := evaluation.Options{
+		Backend:  cfg.Backend,
+		Features: cfg.Features,
+		Method:   evaluation.MethodKFold,
+		Folds:    *folds,
+		Seed:     *seed,
+
+		Validation: cfg.Validation,
+	}
+	if *holdout > 0 {
+		opts.Method = evaluation.MethodHoldout
+		opts.Holdout = *holdout
+	}
+
+	fc := firefly.NewFireFlyHttpClient(cfg.FFApp, cfg.APIKey, config.FireflyAppTimeout, l)
+	fc.ExcludedTypes = cfg.TrainingExcludedTypes
+	l.Logf("INFO getting transactions for evaluation")
+	trnDataset, err := fc.GetTransactionsDataset(*start, *end)
+	if err != nil {
+		return err
+	}
+
+	report, err := evaluation.Evaluate(trnDataset, opts, l)
+	if err != nil {
+		return err
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	fmt.Print(report.Text())
+	return nil
+}
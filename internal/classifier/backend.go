@@ -0,0 +1,148 @@
+package classifier
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/go-pkgz/lgr"
+)
+
+// names of classifier backends
+const (
+	BackendBayesian    = "bayesian"    // naive bayes from github.com/navossoc/bayesian
+	BackendMultinomial = "multinomial" // multinomial naive bayes with laplace smoothing
+)
+
+// transaction classification model
+// new classifier is empty and has to be trained or loaded before use
+type Classifier interface {
+	// train model from scratch on transactions data set
+	Train(dataSet TransactionDataSet) error
+	// predict transaction category with scores of all categories
+	Predict(t Transaction) Prediction
+	// save model with its feature options to file
+	Save(modelFile string) error
+	// load model and its feature options from file
+	Load(modelFile string) error
+	// describe trained model
+	Describe() ModelInfo
+}
+
+// classifier able to update its model with corrected category
+type OnlineLearner interface {
+	// forget transaction for old category (if not empty) and learn it for new one
+	Relearn(t Transaction, oldCategory, newCategory string)
+}
+
+// classifier able to explain its decision
+type Explainer interface {
+	// explain transaction classification for top N categories
+	ExplainTransaction(t Transaction, topN int) Explanation
+}
+
+// classification result
+type Prediction struct {
+	Category    string          `json:"category"`
+	Probability float64         `json:"probability"`
+	Scores      []CategoryScore `json:"scores"` // all categories, most likely first
+}
+
+// description of trained model
+type ModelInfo struct {
+	Backend      string         `json:"backend"`
+	Categories   []string       `json:"categories"`
+	FeatureCount int            `json:"feature_count"` // number of distinct features learned
+	Features     FeatureOptions `json:"features"`
+}
+
+// constructors of empty classifiers by backend name
+var backends = map[string]func(opts FeatureOptions, l *lgr.Logger) (Classifier, error){
+	BackendBayesian: func(opts FeatureOptions, l *lgr.Logger) (Classifier, error) {
+		return newTrnClassifier(opts, l)
+	},
+	BackendMultinomial: func(opts FeatureOptions, l *lgr.Logger) (Classifier, error) {
+		return newMultinomialClassifier(opts, l)
+	},
+}
+
+// get names of available backends
+func Backends() []string {
+	var res []string
+	for name := range backends {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// init empty classifier of given backend
+func NewClassifier(backend string, opts FeatureOptions, l *lgr.Logger) (Classifier, error) {
+	newBackend, exist := backends[backend]
+	if !exist {
+		return nil, fmt.Errorf("unknown classifier backend '%s', available: %v", backend, Backends())
+	}
+	return newBackend(opts, l)
+}
+
+// init classifier of given backend with training data set
+func NewClassifierWithTraining(backend string, dataSet TransactionDataSet, opts FeatureOptions, l *lgr.Logger) (Classifier, error) {
+	cls, err := NewClassifier(backend, opts, l)
+	if err != nil {
+		return nil, err
+	}
+	err = cls.Train(dataSet)
+	if err != nil {
+		return nil, err
+	}
+	return cls, nil
+}
+
+// init classifier with model file
+// backend and feature options recorded in model file are used
+func NewClassifierFromFile(modelFile string, l *lgr.Logger) (Classifier, error) {
+	data, err := readModelFile(modelFile)
+	if err != nil {
+		return nil, err
+	}
+	cls, err := NewClassifier(data.Backend, FeatureOptions{}, l)
+	if err != nil {
+		return nil, err
+	}
+	err = cls.Load(modelFile)
+	if err != nil {
+		return nil, err
+	}
+	return cls, nil
+}
+
+// build prediction from categories and their log scores
+func rankScores(categories []string, logScores []float64) Prediction {
+	probs := logScoresToProbabilities(logScores)
+	scores := make([]CategoryScore, len(categories))
+	for i, cat := range categories {
+		scores[i] = CategoryScore{
+			Category:    cat,
+			Probability: probs[i],
+			LogScore:    logScores[i],
+		}
+	}
+	sort.SliceStable(scores, func(a, b int) bool {
+		return scores[a].Probability > scores[b].Probability
+	})
+	if len(scores) == 0 {
+		return Prediction{Scores: scores}
+	}
+	return Prediction{
+		Category:    scores[0].Category,
+		Probability: scores[0].Probability,
+		Scores:      scores,
+	}
+}
+
+// take top N scores, all if N is not positive
+func topScores(scores []CategoryScore, topN int) []CategoryScore {
+	if topN > 0 && topN < len(scores) {
+		return scores[:topN]
+	}
+	return scores
+}
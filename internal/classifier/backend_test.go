@@ -0,0 +1,69 @@
+package classifier
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-pkgz/lgr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackends(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+
+	for _, backend := range Backends() {
+		t.Run(backend, func(t *testing.T) {
+			cls, err := NewClassifierWithTraining(backend, testDataSet, FeatureOptions{CaseFold: true}, logger)
+			assert.NoError(t, err)
+
+			info := cls.Describe()
+			assert.Equal(t, backend, info.Backend)
+			assert.ElementsMatch(t, []string{"Groceries", "Transport"}, info.Categories)
+			assert.Positive(t, info.FeatureCount)
+
+			pred := cls.Predict(Transaction{Description: "woolworths metro"})
+			assert.Equal(t, "Groceries", pred.Category)
+			assert.Len(t, pred.Scores, 2)
+			assert.Equal(t, pred.Category, pred.Scores[0].Category)
+			assert.InDelta(t, 1, pred.Scores[0].Probability+pred.Scores[1].Probability, 1e-9)
+
+			// relearn adds new category
+			cls.(OnlineLearner).Relearn(Transaction{Description: "PETBARN"}, "", "Pets")
+			assert.Equal(t, "Pets", cls.Predict(Transaction{Description: "PETBARN"}).Category)
+
+			exp := cls.(Explainer).ExplainTransaction(Transaction{Description: "PETBARN"}, 1)
+			assert.Equal(t, "Pets", exp.Categories[0].Category)
+
+			// backend and feature options are loaded from model file
+			modelFile := filepath.Join(t.TempDir(), "model.gob")
+			assert.NoError(t, cls.Save(modelFile))
+			loaded, err := NewClassifierFromFile(modelFile, logger)
+			assert.NoError(t, err)
+			assert.Equal(t, cls.Describe(), loaded.Describe())
+			assert.Equal(t, "Pets", loaded.Predict(Transaction{Description: "PETBARN"}).Category)
+		})
+	}
+
+	t.Run("UnknownBackend", func(t *testing.T) {
+		_, err := NewClassifier("magic", FeatureOptions{}, logger)
+		assert.Error(t, err)
+	})
+
+	t.Run("SingleCategory", func(t *testing.T) {
+		for _, backend := range Backends() {
+			_, err := NewClassifierWithTraining(backend, testDataSet[:2], FeatureOptions{}, logger)
+			assert.Error(t, err)
+		}
+	})
+
+	t.Run("BackendMismatch", func(t *testing.T) {
+		modelFile := filepath.Join(t.TempDir(), "model.gob")
+		cls, err := NewClassifierWithTraining(BackendMultinomial, testDataSet, FeatureOptions{}, logger)
+		assert.NoError(t, err)
+		assert.NoError(t, cls.Save(modelFile))
+
+		other, err := NewClassifier(BackendBayesian, FeatureOptions{}, logger)
+		assert.NoError(t, err)
+		assert.Error(t, other.Load(modelFile))
+	})
+}
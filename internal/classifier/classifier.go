@@ -1,79 +1,365 @@
 package classifier
 
 import (
+	"bytes"
+	"fmt"
+	"math"
 	"regexp"
 	"slices"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-pkgz/lgr"
 	"github.com/navossoc/bayesian"
 )
 
-// classifier implementation
+// classifier backend based on github.com/navossoc/bayesian
 type TrnClassifier struct {
 	Classifier *bayesian.Classifier
 	logger     *lgr.Logger
+	normaliser *normaliser
+	mu         sync.RWMutex // guards Classifier during online learning
 }
 
-type TransactionDataSet [][]string
+var (
+	_ Classifier    = (*TrnClassifier)(nil)
+	_ OnlineLearner = (*TrnClassifier)(nil)
+	_ Explainer     = (*TrnClassifier)(nil)
+)
 
-// init classifier with model file
-func NewTrnClassifierFromFile(modelFile string, l *lgr.Logger) (*TrnClassifier, error) {
-	cls, err := bayesian.NewClassifierFromFile(modelFile)
+// transaction data used for training and classification
+type Transaction struct {
+	Category        string    `json:"category_name,omitempty"`
+	Description     string    `json:"description"`
+	Amount          float64   `json:"amount,omitempty"`
+	Type            string    `json:"type,omitempty"` // withdrawal, deposit, transfer...
+	SourceName      string    `json:"source_name,omitempty"`
+	SourceIBAN      string    `json:"source_iban,omitempty"`
+	DestinationName string    `json:"destination_name,omitempty"`
+	DestinationIBAN string    `json:"destination_iban,omitempty"`
+	Currency        string    `json:"currency_code,omitempty"`
+	Date            time.Time `json:"date,omitempty"`
+	Tags            []string  `json:"tags,omitempty"`
+	Budget          string    `json:"budget_name,omitempty"`
+}
+
+type TransactionDataSet []Transaction
+
+// probability bayesian classifier gives to features it has never seen
+const unseenFeatureProb = 0.00000000001
+
+// category with its probability for classified transaction
+type CategoryScore struct {
+	Category    string  `json:"category"`
+	Probability float64 `json:"probability"`
+	LogScore    float64 `json:"log_score"`
+}
+
+// log probability of feature for each category,
+// known is false if feature was not seen during training
+type FeatureContribution struct {
+	Feature       string             `json:"feature"`
+	Known         bool               `json:"known"`
+	Contributions map[string]float64 `json:"contributions"`
+}
+
+// explanation of transaction classification
+type Explanation struct {
+	Description   string                `json:"description"`
+	Features      []string              `json:"features"`
+	Categories    []CategoryScore       `json:"categories"`
+	Contributions []FeatureContribution `json:"contributions"`
+}
+
+// init empty classifier
+func newTrnClassifier(opts FeatureOptions, l *lgr.Logger) (*TrnClassifier, error) {
+	n, err := newNormaliser(opts)
 	if err != nil {
 		return nil, err
 	}
 	return &TrnClassifier{
-		Classifier: cls,
 		logger:     l,
+		normaliser: n,
 	}, nil
 }
 
+// init classifier with model file
+// feature options recorded in model file are used
+func NewTrnClassifierFromFile(modelFile string, l *lgr.Logger) (*TrnClassifier, error) {
+	tc, err := newTrnClassifier(FeatureOptions{}, l)
+	if err != nil {
+		return nil, err
+	}
+	err = tc.Load(modelFile)
+	if err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
 // init classifier with training data set
-func NewTrnClassifierWithTraining(dataSet TransactionDataSet, l *lgr.Logger) (*TrnClassifier, error) {
-	trainingMap := convertDatasetToTrainingMap(dataSet)
+func NewTrnClassifierWithTraining(dataSet TransactionDataSet, opts FeatureOptions, l *lgr.Logger) (*TrnClassifier, error) {
+	tc, err := newTrnClassifier(opts, l)
+	if err != nil {
+		return nil, err
+	}
+	err = tc.Train(dataSet)
+	if err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// train model from scratch on data set
+// bayesian model keeps whole counts, so weighted feature counts
+// are scaled and rounded, but never down to zero
+func (tc *TrnClassifier) Train(dataSet TransactionDataSet) error {
+	dataSet, weights := prepareTrainingSet(dataSet, tc.normaliser.opts.Training)
+	trainingMap := convertDatasetToTrainingMap(dataSet, tc.normaliser)
 	catList := getCategoriesFromTrainingMap(trainingMap)
 	//catList := maps.Keys(trainingMap)
-	cls := bayesian.NewClassifier(catList...)
-	for _, cat := range catList {
-		cls.Learn(trainingMap[string(cat)], cat)
+	if len(catList) < 2 {
+		return fmt.Errorf("at least 2 categories are required for training, got %d", len(catList))
 	}
-	return &TrnClassifier{
-		Classifier: cls,
-		logger:     l,
-	}, nil
+	var cls *bayesian.Classifier
+	if weights == nil {
+		cls = bayesian.NewClassifier(catList...)
+		for _, cat := range catList {
+			cls.Learn(trainingMap[string(cat)], cat)
+		}
+	} else {
+		weighted := make(map[bayesian.Class]map[string]float64)
+		for i, trn := range dataSet {
+			category, features := getCategoryAndFeatures(trn, tc.normaliser)
+			if weighted[bayesian.Class(category)] == nil {
+				weighted[bayesian.Class(category)] = make(map[string]float64)
+			}
+			for _, f := range features {
+				weighted[bayesian.Class(category)][f] += weights[i]
+			}
+		}
+		counts := make(map[bayesian.Class]map[string]int)
+		for class, words := range weighted {
+			counts[class] = make(map[string]int)
+			for word, count := range words {
+				counts[class][word] = int(math.Max(math.Round(count*weightScale), 1))
+			}
+		}
+		cls = newClassifierFromCounts(catList, counts)
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.Classifier = cls
+	return nil
+}
+
+// save classifier with its feature options to model file
+func (tc *TrnClassifier) Save(modelFile string) error {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	var model bytes.Buffer
+	err := tc.Classifier.WriteTo(&model)
+	if err != nil {
+		return err
+	}
+	return writeModelFile(modelFile, modelData{
+		Backend:  BackendBayesian,
+		Features: tc.normaliser.opts,
+		Model:    model.Bytes(),
+	})
+}
+
+// load classifier and its feature options from model file
+// old model files have none, so they get empty ones
+func (tc *TrnClassifier) Load(modelFile string) error {
+	data, err := readBackendModelFile(modelFile, BackendBayesian)
+	if err != nil {
+		return err
+	}
+	n, err := newNormaliser(data.Features)
+	if err != nil {
+		return err
+	}
+	cls, err := bayesian.NewClassifierFromReader(bytes.NewReader(data.Model))
+	if err != nil {
+		return err
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.Classifier = cls
+	tc.normaliser = n
+	return nil
 }
 
-// save classifier to model file
-func (tc *TrnClassifier) SaveClassifierToFile(modelFile string) error {
-	err := tc.Classifier.WriteToFile(modelFile)
-	return err
+// describe trained model
+func (tc *TrnClassifier) Describe() ModelInfo {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	features := make(map[string]bool)
+	for _, class := range tc.Classifier.Classes {
+		for word := range tc.Classifier.WordsByClass(class) {
+			features[word] = true
+		}
+	}
+	return ModelInfo{
+		Backend:      BackendBayesian,
+		Categories:   tc.categories(),
+		FeatureCount: len(features),
+		Features:     tc.normaliser.opts,
+	}
 }
 
 // perform transaction classification
-// in: transaction description
-// out: likely transaction category
-func (tc *TrnClassifier) ClassifyTransaction(t string) string {
-	features := extractTransactionFeatures(t)
-	_, likely, _ := tc.Classifier.LogScores(features)
-	return string(tc.Classifier.Classes[likely])
+// in: transaction
+// out: likely transaction category, its probability
+// and scores of all categories
+func (tc *TrnClassifier) Predict(t Transaction) Prediction {
+	features := extractTransactionFeatures(t, tc.normaliser)
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	return rankScores(tc.categories(), tc.logScores(features))
 }
 
-// function to get category and list of
-// unique features from line of transaction data set
-// in: [cat, trn description]
-// out: cat, [features...]
-func getCategoryAndFeatures(data []string) (string, []string) {
-	category := data[0]
-	words := strings.Split(data[1], " ")
-	var features []string
-	for _, word := range words {
-		if (validFeature(word)) && (!slices.Contains(features, word)) {
-			features = append(features, word)
+// log scores of categories with priors of training options
+// caller must hold the lock
+func (tc *TrnClassifier) logScores(features []string) []float64 {
+	scores, _, _ := tc.Classifier.LogScores(features)
+	// bayesian model learns priors from feature counts
+	totals := tc.Classifier.WordCount()
+	sum := 0
+	for _, total := range totals {
+		sum += total
+	}
+	priors := make([]float64, len(totals))
+	for i, total := range totals {
+		if sum > 0 {
+			priors[i] = float64(total) / float64(sum)
+		}
+	}
+	return tc.normaliser.opts.Training.adjustLogScores(scores, priors)
+}
+
+// convert log scores to probabilities that sum up to 1
+// scores are shifted by max score to avoid underflow
+func logScoresToProbabilities(scores []float64) []float64 {
+	maxScore := math.Inf(-1)
+	for _, score := range scores {
+		if score > maxScore {
+			maxScore = score
+		}
+	}
+	probs := make([]float64, len(scores))
+	if math.IsInf(maxScore, -1) {
+		// nothing to tell categories apart
+		for i := range probs {
+			probs[i] = 1 / float64(len(probs))
+		}
+		return probs
+	}
+	sum := 0.0
+	for i, score := range scores {
+		probs[i] = math.Exp(score - maxScore)
+		sum += probs[i]
+	}
+	for i := range probs {
+		probs[i] /= sum
+	}
+	return probs
+}
+
+// explain transaction classification
+// in: transaction and number of top categories to report
+// out: features, top categories with their probability and
+// contribution of every feature to top categories
+func (tc *TrnClassifier) ExplainTransaction(t Transaction, topN int) Explanation {
+	features := extractTransactionFeatures(t, tc.normaliser)
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+
+	top := topScores(rankScores(tc.categories(), tc.logScores(features)).Scores, topN)
+
+	// freqs[class][feature] = P(feature|class)
+	freqs := tc.Classifier.WordFrequencies(features)
+	classIndex := make(map[string]int)
+	for i, class := range tc.Classifier.Classes {
+		classIndex[string(class)] = i
+	}
+	explanation := Explanation{
+		Description:   t.Description,
+		Features:      features,
+		Categories:    top,
+		Contributions: []FeatureContribution{},
+	}
+	for j, feature := range features {
+		fc := FeatureContribution{
+			Feature:       feature,
+			Contributions: make(map[string]float64),
+		}
+		for _, score := range top {
+			fc.Contributions[score.Category] = math.Log(freqs[classIndex[score.Category]][j])
+		}
+		for i := range tc.Classifier.Classes {
+			if freqs[i][j] > unseenFeatureProb {
+				fc.Known = true
+				break
+			}
 		}
+		explanation.Contributions = append(explanation.Contributions, fc)
 	}
-	return category, features
+	return explanation
+}
+
+// get list of categories known to classifier
+// caller must hold the lock
+func (tc *TrnClassifier) categories() []string {
+	var res []string
+	for _, cls := range tc.Classifier.Classes {
+		res = append(res, string(cls))
+	}
+	return res
+}
 
+// update model with corrected transaction category
+// features of transaction are forgotten for old category
+// (if not empty) and learned for new category.
+// new category is added to the model if it does not exist yet
+func (tc *TrnClassifier) Relearn(t Transaction, oldCategory, newCategory string) {
+	features := extractTransactionFeatures(t, tc.normaliser)
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	// bayesian classifier can not forget words and has fixed set of classes
+	// so we take word counts out of the model, adjust them and build new one
+	// weighted models are scaled, transaction counts the same as in training
+	scale := tc.normaliser.opts.Training.countScale()
+	classes, counts := getModelCounts(tc.Classifier)
+	if oldCategory != "" {
+		if old, exist := counts[bayesian.Class(oldCategory)]; exist {
+			for _, f := range features {
+				old[f] -= scale
+				if old[f] <= 0 {
+					delete(old, f)
+				}
+			}
+		}
+	}
+	newCls := bayesian.Class(newCategory)
+	if _, exist := counts[newCls]; !exist {
+		classes = append(classes, newCls)
+		counts[newCls] = make(map[string]int)
+	}
+	for _, f := range features {
+		counts[newCls][f] += scale
+	}
+	tc.Classifier = newClassifierFromCounts(classes, counts)
+}
+
+// function to get category and list of
+// unique features from transaction of data set
+// in: transaction
+// out: cat, [features...]
+func getCategoryAndFeatures(data Transaction, n *normaliser) (string, []string) {
+	return data.Category, extractTransactionFeatures(data, n)
 }
 
 // get slice of categories from training map
@@ -85,6 +371,34 @@ func getCategoriesFromTrainingMap(training map[string][]string) []bayesian.Class
 	return result
 }
 
+// get classes and per class word counts from bayesian model
+func getModelCounts(cls *bayesian.Classifier) ([]bayesian.Class, map[bayesian.Class]map[string]int) {
+	classes := slices.Clone(cls.Classes)
+	totals := cls.WordCount()
+	counts := make(map[bayesian.Class]map[string]int)
+	for i, class := range classes {
+		counts[class] = make(map[string]int)
+		for word, prob := range cls.WordsByClass(class) {
+			// model keeps word probability, so convert it back to count
+			counts[class][word] = int(math.Round(prob * float64(totals[i])))
+		}
+	}
+	return classes, counts
+}
+
+// build bayesian model from classes and per class word counts
+func newClassifierFromCounts(classes []bayesian.Class, counts map[bayesian.Class]map[string]int) *bayesian.Classifier {
+	cls := bayesian.NewClassifier(classes...)
+	for class, words := range counts {
+		for word, count := range words {
+			if count > 0 {
+				cls.Observe(word, count, class)
+			}
+		}
+	}
+	return cls
+}
+
 // checks if feature is valid
 // should be not single symbol and not pure number
 func validFeature(feature string) bool {
@@ -99,14 +413,14 @@ func isStringNumeric(s string) bool {
 }
 
 // build training map from transactions data set
-// in: [ [cat, trn description], [cat, trn description]... ]
+// in: [ {cat, trn description, ...}, {cat, trn description, ...}... ]
 // out: map[Category] = [feature1, feature2, ...]
-func convertDatasetToTrainingMap(dataSet TransactionDataSet) map[string][]string {
+func convertDatasetToTrainingMap(dataSet TransactionDataSet, n *normaliser) map[string][]string {
 	resultMap := make(map[string][]string)
 	var features []string
 	var category string
 	for _, line := range dataSet {
-		category, features = getCategoryAndFeatures(line)
+		category, features = getCategoryAndFeatures(line, n)
 		_, exist := resultMap[category]
 		if exist {
 			resultMap[category] = append(resultMap[category], features...)
@@ -117,14 +431,30 @@ func convertDatasetToTrainingMap(dataSet TransactionDataSet) map[string][]string
 	return resultMap
 }
 
-// extract unique words from transaction description that are not numeric
-func extractTransactionFeatures(transaction string) []string {
+// extract unique features from transaction:
+// normalised words from description, optional character n-grams
+// and word bigrams, and synthetic features from amount, type,
+// accounts and currency
+func extractTransactionFeatures(transaction Transaction, n *normaliser) []string {
 	var transFeatures []string
-	features := strings.Split(transaction, " ")
-	for _, feature := range features {
-		if (len(feature) > 1) && (!slices.Contains(transFeatures, feature)) && (!isStringNumeric(feature)) {
-			transFeatures = append(transFeatures, feature)
+	add := func(features []string) {
+		for _, feature := range features {
+			if !slices.Contains(transFeatures, feature) {
+				transFeatures = append(transFeatures, feature)
+			}
+		}
+	}
+
+	words := n.words(transaction.Description)
+	add(words)
+	if n.opts.CharNGrams > 0 {
+		for _, word := range words {
+			add(charNGrams(word, n.opts.CharNGrams))
 		}
 	}
+	if n.opts.WordBigrams {
+		add(wordBigrams(words))
+	}
+	add(syntheticFeatures(transaction))
 	return transFeatures
 }
@@ -0,0 +1,121 @@
+package classifier
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-pkgz/lgr"
+	"github.com/stretchr/testify/assert"
+)
+
+var testDataSet = TransactionDataSet{
+	{Category: "Groceries", Description: "WOOLWORTHS SYDNEY"},
+	{Category: "Groceries", Description: "COLES SYDNEY"},
+	{Category: "Transport", Description: "OPAL TRAVEL"},
+	{Category: "Transport", Description: "UBER TRIP"},
+}
+
+func TestRelearn(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+
+	t.Run("ExistingCategory", func(t *testing.T) {
+		cls, err := NewTrnClassifierWithTraining(testDataSet, FeatureOptions{}, logger)
+		assert.NoError(t, err)
+		cat := cls.Predict(Transaction{Description: "WOOLWORTHS METRO"}).Category
+		assert.Equal(t, "Groceries", cat)
+
+		cls.Relearn(Transaction{Description: "WOOLWORTHS METRO"}, "Groceries", "Transport")
+		cls.Relearn(Transaction{Description: "WOOLWORTHS METRO"}, "Groceries", "Transport")
+		cat = cls.Predict(Transaction{Description: "WOOLWORTHS METRO"}).Category
+		assert.Equal(t, "Transport", cat)
+	})
+
+	t.Run("NewCategory", func(t *testing.T) {
+		cls, err := NewTrnClassifierWithTraining(testDataSet, FeatureOptions{}, logger)
+		assert.NoError(t, err)
+
+		cls.Relearn(Transaction{Description: "PETBARN"}, "", "Pets")
+		assert.Contains(t, cls.Describe().Categories, "Pets")
+		cat := cls.Predict(Transaction{Description: "PETBARN"}).Category
+		assert.Equal(t, "Pets", cat)
+	})
+
+	// weighted model counts transaction the same as in training
+	t.Run("WeightedModel", func(t *testing.T) {
+		cls, err := NewTrnClassifierWithTraining(testDataSet, FeatureOptions{Training: TrainingOptions{Balance: true}}, logger)
+		assert.NoError(t, err)
+
+		cls.Relearn(Transaction{Description: "WOOLWORTHS METRO"}, "Groceries", "Transport")
+		cls.Relearn(Transaction{Description: "WOOLWORTHS METRO"}, "Groceries", "Transport")
+		cat := cls.Predict(Transaction{Description: "WOOLWORTHS METRO"}).Category
+		assert.Equal(t, "Transport", cat)
+		_, counts := getModelCounts(cls.Classifier)
+		assert.Equal(t, 2*weightScale, counts["Transport"]["WOOLWORTHS"])
+	})
+}
+
+func TestSave(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	modelFile := filepath.Join(t.TempDir(), "model.gob")
+
+	cls, err := NewTrnClassifierWithTraining(testDataSet, FeatureOptions{}, logger)
+	assert.NoError(t, err)
+	cls.Relearn(Transaction{Description: "PETBARN"}, "", "Pets")
+	assert.NoError(t, cls.Save(modelFile))
+
+	loaded, err := NewTrnClassifierFromFile(modelFile, logger)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, cls.Describe().Categories, loaded.Describe().Categories)
+	cat := loaded.Predict(Transaction{Description: "PETBARN"}).Category
+	assert.Equal(t, "Pets", cat)
+}
+
+func TestLoadLegacyModelFile(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	modelFile := filepath.Join(t.TempDir(), "model.gob")
+
+	// model files written before feature options were recorded
+	// contain bare bayesian classifier
+	cls, err := NewTrnClassifierWithTraining(testDataSet, FeatureOptions{}, logger)
+	assert.NoError(t, err)
+	assert.NoError(t, cls.Classifier.WriteToFile(modelFile))
+
+	// they were trained without feature options, whatever is configured now
+	loaded, err := NewClassifierFromFile(modelFile, logger)
+	assert.NoError(t, err)
+	assert.Equal(t, BackendBayesian, loaded.Describe().Backend)
+	assert.Equal(t, FeatureOptions{}, loaded.Describe().Features)
+	assert.ElementsMatch(t, cls.Describe().Categories, loaded.Describe().Categories)
+	trn := Transaction{Description: "WOOLWORTHS SYDNEY"}
+	assert.Equal(t, cls.Predict(trn), loaded.Predict(trn))
+}
+
+func TestClassifyTransactionConfidence(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	cls, err := NewTrnClassifierWithTraining(testDataSet, FeatureOptions{}, logger)
+	assert.NoError(t, err)
+
+	pred := cls.Predict(Transaction{Description: "WOOLWORTHS SYDNEY"})
+	assert.Equal(t, "Groceries", pred.Category)
+	assert.Greater(t, pred.Probability, 0.9)
+
+	// nothing known about description, so probability is close to priors
+	pred = cls.Predict(Transaction{Description: "SOMETHING ELSE"})
+	assert.InDelta(t, 0.5, pred.Probability, 0.01)
+}
+
+func TestExplainTransaction(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	cls, err := NewTrnClassifierWithTraining(testDataSet, FeatureOptions{}, logger)
+	assert.NoError(t, err)
+
+	exp := cls.ExplainTransaction(Transaction{Description: "WOOLWORTHS 123 UNKNOWN"}, 1)
+	assert.Equal(t, []string{"WOOLWORTHS", "UNKNOWN"}, exp.Features)
+	assert.Len(t, exp.Categories, 1)
+	assert.Equal(t, "Groceries", exp.Categories[0].Category)
+	assert.Len(t, exp.Contributions, 2)
+	assert.True(t, exp.Contributions[0].Known)
+	assert.False(t, exp.Contributions[1].Known)
+	assert.Contains(t, exp.Contributions[0].Contributions, "Groceries")
+	assert.NotContains(t, exp.Contributions[0].Contributions, "Transport")
+}
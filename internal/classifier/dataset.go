@@ -0,0 +1,126 @@
+package classifier
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const minCategories = 2 // bayesian backend can not be trained on less
+
+// options of training data set validation
+type ValidationOptions struct {
+	IgnoredCategories []string // never learned, e.g. "Uncategorised"
+	MinSamples        int      // categories with fewer transactions are dropped or merged
+	MergeInto         string   // category small ones are merged into, they are dropped if empty
+}
+
+// what was kept and discarded from training data set
+type DataSetSummary struct {
+	Total         int            `json:"total"`
+	Kept          int            `json:"kept"`
+	Categories    map[string]int `json:"categories"`    // kept transactions by category
+	Uncategorised int            `json:"uncategorised"` // dropped transactions without category
+	Ignored       map[string]int `json:"ignored,omitempty"`
+	Dropped       map[string]int `json:"dropped,omitempty"` // small categories
+	Merged        map[string]int `json:"merged,omitempty"`  // small categories merged into MergedInto
+	MergedInto    string         `json:"merged_into,omitempty"`
+}
+
+func (s DataSetSummary) String() string {
+	msg := fmt.Sprintf("kept %d of %d transactions in %d categories, %d uncategorised dropped",
+		s.Kept, s.Total, len(s.Categories), s.Uncategorised)
+	if len(s.Ignored) > 0 {
+		msg += fmt.Sprintf(", ignored categories dropped: %s", formatCounts(s.Ignored))
+	}
+	if len(s.Dropped) > 0 {
+		msg += fmt.Sprintf(", small categories dropped: %s", formatCounts(s.Dropped))
+	}
+	if len(s.Merged) > 0 {
+		msg += fmt.Sprintf(", small categories merged into '%s': %s", s.MergedInto, formatCounts(s.Merged))
+	}
+	return msg
+}
+
+// validate data set before training category model
+// transactions without category or with ignored one are dropped, and
+// categories with less than min samples are dropped or merged
+// returns error if less than 2 categories are left
+func ValidateDataSet(dataSet TransactionDataSet, opts ValidationOptions) (TransactionDataSet, DataSetSummary, error) {
+	summary := DataSetSummary{
+		Total:      len(dataSet),
+		Categories: make(map[string]int),
+		Ignored:    make(map[string]int),
+		Dropped:    make(map[string]int),
+		Merged:     make(map[string]int),
+	}
+
+	counts := make(map[string]int)
+	for _, trn := range dataSet {
+		counts[trn.Category]++
+	}
+
+	res := TransactionDataSet{}
+	for _, trn := range dataSet {
+		switch {
+		case strings.TrimSpace(trn.Category) == "":
+			summary.Uncategorised++
+			continue
+		case opts.Ignores(trn.Category):
+			summary.Ignored[trn.Category]++
+			continue
+		case counts[trn.Category] < opts.MinSamples && opts.MergeInto == "":
+			summary.Dropped[trn.Category]++
+			continue
+		case counts[trn.Category] < opts.MinSamples && trn.Category != opts.MergeInto:
+			summary.Merged[trn.Category]++
+			trn.Category = opts.MergeInto
+			summary.MergedInto = opts.MergeInto
+		}
+		res = append(res, trn)
+		summary.Categories[trn.Category]++
+	}
+	summary.Kept = len(res)
+
+	if len(summary.Categories) < minCategories {
+		return nil, summary, fmt.Errorf("classifier needs at least %d different categories in transactions, got %d (%s)",
+			minCategories, len(summary.Categories), summary)
+	}
+	return res, summary, nil
+}
+
+// data set for budget classifier
+// budget of every transaction is used as its category, transactions
+// without budget are kept so that model learns when not to set one
+func BudgetDataSet(dataSet TransactionDataSet) TransactionDataSet {
+	res := make(TransactionDataSet, 0, len(dataSet))
+	for _, trn := range dataSet {
+		trn.Category = trn.Budget
+		res = append(res, trn)
+	}
+	return res
+}
+
+// check if category is never learned, case is ignored
+func (opts ValidationOptions) Ignores(category string) bool {
+	for _, c := range opts.IgnoredCategories {
+		if strings.EqualFold(category, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// format counts as "name (count)" sorted by name
+func formatCounts(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	items := make([]string, 0, len(names))
+	for _, name := range names {
+		items = append(items, fmt.Sprintf("%s (%d)", name, counts[name]))
+	}
+	return strings.Join(items, ", ")
+}
@@ -0,0 +1,77 @@
+package classifier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testValidationDataSet = TransactionDataSet{
+	{Category: "Groceries", Description: "WOOLWORTHS METRO"},
+	{Category: "Groceries", Description: "COLES SUPERMARKET"},
+	{Category: "Transport", Description: "UBER TRIP"},
+	{Category: "Transport", Description: "OPAL TOPUP"},
+	{Category: "Gifts", Description: "FLOWERS"},
+	{Category: "Pets", Description: "VET CLINIC"},
+	{Category: "Uncategorised", Description: "ATM WITHDRAWAL"},
+	{Description: "NEW SHOP"},
+	{Category: " ", Description: "OTHER SHOP"},
+}
+
+func TestValidateDataSet(t *testing.T) {
+	res, summary, err := ValidateDataSet(testValidationDataSet, ValidationOptions{
+		IgnoredCategories: []string{"uncategorised"},
+		MinSamples:        2,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, res, 4)
+	assert.Equal(t, DataSetSummary{
+		Total:         9,
+		Kept:          4,
+		Categories:    map[string]int{"Groceries": 2, "Transport": 2},
+		Uncategorised: 2,
+		Ignored:       map[string]int{"Uncategorised": 1},
+		Dropped:       map[string]int{"Gifts": 1, "Pets": 1},
+		Merged:        map[string]int{},
+	}, summary)
+	assert.Equal(t, "kept 4 of 9 transactions in 2 categories, 2 uncategorised dropped, "+
+		"ignored categories dropped: Uncategorised (1), small categories dropped: Gifts (1), Pets (1)", summary.String())
+
+	// data set is not changed
+	assert.Equal(t, "Gifts", testValidationDataSet[4].Category)
+}
+
+func TestValidateDataSetMerge(t *testing.T) {
+	res, summary, err := ValidateDataSet(testValidationDataSet, ValidationOptions{MinSamples: 2, MergeInto: "Other"})
+	assert.NoError(t, err)
+	assert.Len(t, res, 7)
+	assert.Equal(t, map[string]int{"Groceries": 2, "Transport": 2, "Other": 3}, summary.Categories)
+	assert.Equal(t, map[string]int{"Gifts": 1, "Pets": 1, "Uncategorised": 1}, summary.Merged)
+	assert.Equal(t, "Other", summary.MergedInto)
+	assert.Equal(t, "Other", res[4].Category)
+}
+
+func TestValidateDataSetNotEnoughCategories(t *testing.T) {
+	_, summary, err := ValidateDataSet(testValidationDataSet, ValidationOptions{MinSamples: 3})
+	assert.Error(t, err)
+	assert.Empty(t, summary.Categories)
+	assert.Len(t, summary.Dropped, 5)
+
+	_, _, err = ValidateDataSet(nil, ValidationOptions{})
+	assert.Error(t, err)
+}
+
+func TestBudgetDataSet(t *testing.T) {
+	dataSet := TransactionDataSet{
+		{Category: "Groceries", Budget: "Food", Description: "WOOLWORTHS"},
+		{Category: "Transport", Description: "UBER TRIP"},
+	}
+	budgets := BudgetDataSet(dataSet)
+	if assert.Len(t, budgets, 2) {
+		assert.Equal(t, "Food", budgets[0].Category)
+		assert.Equal(t, "WOOLWORTHS", budgets[0].Description)
+		// transactions without budget are kept
+		assert.Equal(t, "", budgets[1].Category)
+	}
+	assert.Equal(t, "Groceries", dataSet[0].Category)
+}
@@ -0,0 +1,86 @@
+package classifier
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// prefixes of synthetic features
+// to keep them apart from description words
+const (
+	amountFeaturePrefix   = "amt:"
+	typeFeaturePrefix     = "type:"
+	accountFeaturePrefix  = "acct:"
+	currencyFeaturePrefix = "cur:"
+	ngramFeaturePrefix    = "ng:"
+	bigramFeaturePrefix   = "bi:"
+	ngramWordBoundary     = '_'
+)
+
+// upper bounds of amount buckets
+var amountBuckets = []float64{10, 20, 50, 100, 200, 500, 1000, 2000, 5000}
+
+// build synthetic features from transaction amount, type,
+// source and destination accounts and currency
+// e.g. [amt:20-50 type:withdrawal acct:Checking acct:Woolworths cur:AUD]
+func syntheticFeatures(t Transaction) []string {
+	var features []string
+	if t.Amount != 0 {
+		features = append(features, amountFeaturePrefix+amountBucket(t.Amount))
+	}
+	if t.Type != "" {
+		features = append(features, typeFeaturePrefix+strings.ToLower(t.Type))
+	}
+	for _, account := range []string{t.SourceName, t.DestinationName} {
+		if account != "" {
+			features = append(features, accountFeaturePrefix+featureToken(account))
+		}
+	}
+	if t.Currency != "" {
+		features = append(features, currencyFeaturePrefix+strings.ToUpper(t.Currency))
+	}
+	return features
+}
+
+// get bucket label for absolute transaction amount
+// e.g. 35.5 -> 20-50, 7000 -> 5000+
+func amountBucket(amount float64) string {
+	amount = math.Abs(amount)
+	lower := 0.0
+	for _, upper := range amountBuckets {
+		if amount < upper {
+			return fmt.Sprintf("%g-%g", lower, upper)
+		}
+		lower = upper
+	}
+	return fmt.Sprintf("%g+", lower)
+}
+
+// make single token from multi word value
+func featureToken(value string) string {
+	return strings.Join(strings.Fields(value), "_")
+}
+
+// get character n-grams of word, word is padded with
+// boundary marks so start and end of word get own n-grams
+// e.g. n=3 for COLES: [ng:_CO ng:COL ng:OLE ng:LES ng:ES_]
+func charNGrams(word string, n int) []string {
+	runes := append([]rune{ngramWordBoundary}, []rune(word)...)
+	runes = append(runes, ngramWordBoundary)
+	var ngrams []string
+	for i := 0; i+n <= len(runes); i++ {
+		ngrams = append(ngrams, ngramFeaturePrefix+string(runes[i:i+n]))
+	}
+	return ngrams
+}
+
+// get pairs of adjacent words
+// e.g. [WOOLWORTHS METRO SYDNEY] -> [bi:WOOLWORTHS_METRO bi:METRO_SYDNEY]
+func wordBigrams(words []string) []string {
+	var bigrams []string
+	for i := 1; i < len(words); i++ {
+		bigrams = append(bigrams, bigramFeaturePrefix+words[i-1]+"_"+words[i])
+	}
+	return bigrams
+}
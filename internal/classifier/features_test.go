@@ -0,0 +1,86 @@
+package classifier
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-pkgz/lgr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyntheticFeatures(t *testing.T) {
+	trn := Transaction{
+		Description:     "WOOLWORTHS",
+		Amount:          -35.5,
+		Type:            "Withdrawal",
+		SourceName:      "Everyday Checking",
+		DestinationName: "Woolworths",
+		Currency:        "aud",
+	}
+	assert.Equal(t,
+		[]string{"amt:20-50", "type:withdrawal", "acct:Everyday_Checking", "acct:Woolworths", "cur:AUD"},
+		syntheticFeatures(trn),
+	)
+	assert.Empty(t, syntheticFeatures(Transaction{Description: "WOOLWORTHS"}))
+}
+
+func TestAmountBucket(t *testing.T) {
+	assert.Equal(t, "0-10", amountBucket(5))
+	assert.Equal(t, "10-20", amountBucket(10))
+	assert.Equal(t, "100-200", amountBucket(-150))
+	assert.Equal(t, "5000+", amountBucket(7000))
+}
+
+func TestClassifyWithSyntheticFeatures(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	dataSet := TransactionDataSet{
+		{Category: "Work lunch", Description: "CAFE", Amount: 15},
+		{Category: "Work lunch", Description: "CAFE", Amount: 12},
+		{Category: "Catering", Description: "CAFE", Amount: 800},
+		{Category: "Catering", Description: "CAFE", Amount: 950},
+	}
+	cls, err := NewTrnClassifierWithTraining(dataSet, FeatureOptions{}, logger)
+	assert.NoError(t, err)
+
+	cat := cls.Predict(Transaction{Description: "CAFE", Amount: 14}).Category
+	assert.Equal(t, "Work lunch", cat)
+	cat = cls.Predict(Transaction{Description: "CAFE", Amount: 700}).Category
+	assert.Equal(t, "Catering", cat)
+}
+
+func TestCharNGrams(t *testing.T) {
+	assert.Equal(t, []string{"ng:_CO", "ng:COL", "ng:OLE", "ng:LES", "ng:ES_"}, charNGrams("COLES", 3))
+	assert.Equal(t, []string{"ng:_ß_"}, charNGrams("ß", 3))
+}
+
+func TestWordBigrams(t *testing.T) {
+	assert.Equal(t,
+		[]string{"bi:WOOLWORTHS_METRO", "bi:METRO_SYDNEY"},
+		wordBigrams([]string{"WOOLWORTHS", "METRO", "SYDNEY"}),
+	)
+	assert.Empty(t, wordBigrams([]string{"WOOLWORTHS"}))
+}
+
+func TestClassifyWithCharNGrams(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	dataSet := TransactionDataSet{
+		{Category: "Groceries", Description: "WOOLWORTHS 1234 SYDNEY"},
+		{Category: "Transport", Description: "OPAL TRAVEL"},
+	}
+	opts := FeatureOptions{CharNGrams: 3}
+	cls, err := NewTrnClassifierWithTraining(dataSet, opts, logger)
+	assert.NoError(t, err)
+
+	// mangled merchant name still shares most n-grams
+	cat := cls.Predict(Transaction{Description: "WOOLWRTHS METRO"}).Category
+	assert.Equal(t, "Groceries", cat)
+
+	// n-gram setting is loaded from model file
+	modelFile := filepath.Join(t.TempDir(), "model.gob")
+	assert.NoError(t, cls.Save(modelFile))
+	loaded, err := NewTrnClassifierFromFile(modelFile, logger)
+	assert.NoError(t, err)
+	assert.Equal(t, opts, loaded.Describe().Features)
+	cat = loaded.Predict(Transaction{Description: "WOOLWRTHS METRO"}).Category
+	assert.Equal(t, "Groceries", cat)
+}
@@ -0,0 +1,83 @@
+package classifier
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// version of model file format
+// files written before feature options were recorded
+// contain bare bayesian model and have no version
+const modelVersion = 1
+
+// content of model file
+// feature options are kept with the model so that classification
+// extracts features exactly the same way training did
+type modelData struct {
+	Version  int
+	Backend  string // empty for files written before backends were introduced
+	Features FeatureOptions
+	Model    []byte // gob encoded backend model
+}
+
+// read model data from file
+// old files with bare bayesian model were trained without any
+// feature options, so they get empty ones
+func readModelFile(name string) (modelData, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return modelData{}, err
+	}
+
+	var data modelData
+	err = gob.NewDecoder(bytes.NewReader(content)).Decode(&data)
+	if err != nil || data.Version == 0 {
+		return modelData{
+			Backend: BackendBayesian,
+			Model:   content,
+		}, nil
+	}
+	if data.Backend == "" {
+		data.Backend = BackendBayesian
+	}
+	return data, nil
+}
+
+// read model data from file and check it is model of expected backend
+func readBackendModelFile(name, backend string) (modelData, error) {
+	data, err := readModelFile(name)
+	if err != nil {
+		return data, err
+	}
+	if data.Backend != backend {
+		return data, fmt.Errorf("model file %s contains '%s' model, expected '%s'", name, data.Backend, backend)
+	}
+	return data, nil
+}
+
+// write model data to file
+// model is written to temp file first and then renamed
+// so model file is never left half written
+func writeModelFile(name string, data modelData) error {
+	data.Version = modelVersion
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = gob.NewEncoder(tmp).Encode(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,250 @@
+package classifier
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/go-pkgz/lgr"
+)
+
+// laplace smoothing for features not seen in category
+const multinomialAlpha = 1.0
+
+// multinomial naive bayes classifier with laplace smoothing
+// unlike bayesian backend, features never seen in training
+// are ignored instead of penalising every category
+type MultinomialClassifier struct {
+	model      *multinomialModel
+	logger     *lgr.Logger
+	normaliser *normaliser
+	mu         sync.RWMutex // guards model during online learning
+}
+
+// serializable multinomial model
+type multinomialModel struct {
+	Categories []string
+	Docs       map[string]float64            // number of transactions per category
+	Counts     map[string]map[string]float64 // feature counts per category
+	Totals     map[string]float64            // sum of feature counts per category
+	Vocabulary map[string]bool               // all features seen in training
+}
+
+var (
+	_ Classifier    = (*MultinomialClassifier)(nil)
+	_ OnlineLearner = (*MultinomialClassifier)(nil)
+	_ Explainer     = (*MultinomialClassifier)(nil)
+)
+
+func newMultinomialModel() *multinomialModel {
+	return &multinomialModel{
+		Docs:       make(map[string]float64),
+		Counts:     make(map[string]map[string]float64),
+		Totals:     make(map[string]float64),
+		Vocabulary: make(map[string]bool),
+	}
+}
+
+// init empty classifier
+func newMultinomialClassifier(opts FeatureOptions, l *lgr.Logger) (*MultinomialClassifier, error) {
+	n, err := newNormaliser(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &MultinomialClassifier{
+		model:      newMultinomialModel(),
+		logger:     l,
+		normaliser: n,
+	}, nil
+}
+
+// train model from scratch on data set
+func (mc *MultinomialClassifier) Train(dataSet TransactionDataSet) error {
+	dataSet, weights := prepareTrainingSet(dataSet, mc.normaliser.opts.Training)
+	model := newMultinomialModel()
+	for i, trn := range dataSet {
+		category, features := getCategoryAndFeatures(trn, mc.normaliser)
+		model.learn(category, features, weightAt(weights, i))
+	}
+	if len(model.Categories) < 2 {
+		return fmt.Errorf("at least 2 categories are required for training, got %d", len(model.Categories))
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.model = model
+	return nil
+}
+
+// save classifier with its feature options to model file
+func (mc *MultinomialClassifier) Save(modelFile string) error {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	var model bytes.Buffer
+	err := gob.NewEncoder(&model).Encode(mc.model)
+	if err != nil {
+		return err
+	}
+	return writeModelFile(modelFile, modelData{
+		Backend:  BackendMultinomial,
+		Features: mc.normaliser.opts,
+		Model:    model.Bytes(),
+	})
+}
+
+// load classifier and its feature options from model file
+func (mc *MultinomialClassifier) Load(modelFile string) error {
+	data, err := readBackendModelFile(modelFile, BackendMultinomial)
+	if err != nil {
+		return err
+	}
+	n, err := newNormaliser(data.Features)
+	if err != nil {
+		return err
+	}
+	model := newMultinomialModel()
+	err = gob.NewDecoder(bytes.NewReader(data.Model)).Decode(model)
+	if err != nil {
+		return err
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.model = model
+	mc.normaliser = n
+	return nil
+}
+
+// describe trained model
+func (mc *MultinomialClassifier) Describe() ModelInfo {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	return ModelInfo{
+		Backend:      BackendMultinomial,
+		Categories:   append([]string{}, mc.model.Categories...),
+		FeatureCount: len(mc.model.Vocabulary),
+		Features:     mc.normaliser.opts,
+	}
+}
+
+// perform transaction classification
+func (mc *MultinomialClassifier) Predict(t Transaction) Prediction {
+	features := extractTransactionFeatures(t, mc.normaliser)
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	return rankScores(mc.model.Categories, mc.logScores(features))
+}
+
+// log scores of categories with priors of training options
+// caller must hold the lock
+func (mc *MultinomialClassifier) logScores(features []string) []float64 {
+	docs := 0.0
+	for _, cat := range mc.model.Categories {
+		docs += mc.model.Docs[cat]
+	}
+	priors := make([]float64, len(mc.model.Categories))
+	for i, cat := range mc.model.Categories {
+		priors[i] = mc.model.Docs[cat] / docs
+	}
+	return mc.normaliser.opts.Training.adjustLogScores(mc.model.logScores(features), priors)
+}
+
+// explain transaction classification
+func (mc *MultinomialClassifier) ExplainTransaction(t Transaction, topN int) Explanation {
+	features := extractTransactionFeatures(t, mc.normaliser)
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	top := topScores(rankScores(mc.model.Categories, mc.logScores(features)).Scores, topN)
+	explanation := Explanation{
+		Description:   t.Description,
+		Features:      features,
+		Categories:    top,
+		Contributions: []FeatureContribution{},
+	}
+	for _, feature := range features {
+		fc := FeatureContribution{
+			Feature:       feature,
+			Known:         mc.model.Vocabulary[feature],
+			Contributions: make(map[string]float64),
+		}
+		// unknown features do not contribute
+		if fc.Known {
+			for _, score := range top {
+				fc.Contributions[score.Category] = mc.model.featureLogProb(score.Category, feature)
+			}
+		}
+		explanation.Contributions = append(explanation.Contributions, fc)
+	}
+	return explanation
+}
+
+// update model with corrected transaction category
+func (mc *MultinomialClassifier) Relearn(t Transaction, oldCategory, newCategory string) {
+	features := extractTransactionFeatures(t, mc.normaliser)
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if oldCategory != "" {
+		mc.model.forget(oldCategory, features)
+	}
+	mc.model.learn(newCategory, features, 1)
+}
+
+// add transaction features to category with given weight
+func (m *multinomialModel) learn(category string, features []string, weight float64) {
+	if _, exist := m.Counts[category]; !exist {
+		m.Categories = append(m.Categories, category)
+		m.Counts[category] = make(map[string]float64)
+	}
+	m.Docs[category] += weight
+	for _, f := range features {
+		m.Counts[category][f] += weight
+		m.Totals[category] += weight
+		m.Vocabulary[f] = true
+	}
+}
+
+// remove transaction features from category
+// counts never go below zero
+func (m *multinomialModel) forget(category string, features []string) {
+	counts, exist := m.Counts[category]
+	if !exist {
+		return
+	}
+	m.Docs[category] = math.Max(m.Docs[category]-1, 0)
+	for _, f := range features {
+		if counts[f] > 0 {
+			removed := math.Min(counts[f], 1)
+			counts[f] -= removed
+			m.Totals[category] -= removed
+		}
+		if counts[f] == 0 {
+			delete(counts, f)
+		}
+	}
+}
+
+// log P(feature|category) with laplace smoothing
+func (m *multinomialModel) featureLogProb(category, feature string) float64 {
+	vocabulary := float64(len(m.Vocabulary))
+	return math.Log((m.Counts[category][feature] + multinomialAlpha) / (m.Totals[category] + multinomialAlpha*vocabulary))
+}
+
+// log score of every category for features
+// features not seen in training are skipped
+func (m *multinomialModel) logScores(features []string) []float64 {
+	docs := 0.0
+	for _, cat := range m.Categories {
+		docs += m.Docs[cat]
+	}
+	scores := make([]float64, len(m.Categories))
+	for i, cat := range m.Categories {
+		scores[i] = math.Log(m.Docs[cat] / docs)
+		for _, f := range features {
+			if m.Vocabulary[f] {
+				scores[i] += m.featureLogProb(cat, f)
+			}
+		}
+	}
+	return scores
+}
@@ -0,0 +1,88 @@
+package classifier
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+)
+
+// feature extraction settings
+// they are recorded in model file, so classification
+// always extracts features the same way training did
+// training settings are recorded together with them
+type FeatureOptions struct {
+	CaseFold         bool     `json:"case_fold"`         // unicode case folding: WOOLWORTHS -> woolworths
+	StripPunctuation bool     `json:"strip_punctuation"` // replace punctuation and symbols with spaces
+	MaskDigits       bool     `json:"mask_digits"`       // replace digits inside words: STORE1234 -> STORE#
+	StopWords        []string `json:"stop_words"`        // words to drop, e.g. POS, VISA
+	StripPatterns    []string `json:"strip_patterns"`    // regular expressions removed from description
+	CharNGrams       int      `json:"char_ngrams"`       // length of character n-grams of words, 0 to disable
+	WordBigrams      bool     `json:"word_bigrams"`      // pairs of adjacent words
+
+	Training TrainingOptions `json:"training"`
+}
+
+// text normalisation pipeline for transaction descriptions
+type normaliser struct {
+	opts          FeatureOptions
+	stripPatterns []*regexp.Regexp
+	stopWords     map[string]bool
+}
+
+var digitsPattern = regexp.MustCompile(`\d+`)
+
+func newNormaliser(opts FeatureOptions) (*normaliser, error) {
+	n := &normaliser{
+		opts:      opts,
+		stopWords: make(map[string]bool),
+	}
+	for _, pattern := range opts.StripPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid strip pattern '%s': %w", pattern, err)
+		}
+		n.stripPatterns = append(n.stripPatterns, re)
+	}
+	// stop words are matched case insensitive
+	for _, word := range opts.StopWords {
+		n.stopWords[cases.Fold().String(strings.TrimSpace(word))] = true
+	}
+	return n, nil
+}
+
+// split description into normalised words
+// pure numbers and single symbols are dropped
+func (n *normaliser) words(description string) []string {
+	for _, re := range n.stripPatterns {
+		description = re.ReplaceAllString(description, " ")
+	}
+	if n.opts.CaseFold {
+		description = cases.Fold().String(description)
+	}
+	if n.opts.StripPunctuation {
+		description = strings.Map(func(r rune) rune {
+			if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+				return ' '
+			}
+			return r
+		}, description)
+	}
+
+	var words []string
+	for _, word := range strings.Fields(description) {
+		if !validFeature(word) {
+			continue
+		}
+		if n.opts.MaskDigits {
+			word = digitsPattern.ReplaceAllString(word, "#")
+		}
+		if len(n.stopWords) > 0 && n.stopWords[cases.Fold().String(word)] {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words
+}
@@ -0,0 +1,67 @@
+package classifier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormaliserWords(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		opts        FeatureOptions
+		description string
+		expected    []string
+	}{
+		{
+			name:        "NoNormalisation",
+			opts:        FeatureOptions{},
+			description: "WOOLWORTHS  1234 Sydney, 12.50",
+			expected:    []string{"WOOLWORTHS", "Sydney,"},
+		},
+		{
+			name:        "CaseFoldAndPunctuation",
+			opts:        FeatureOptions{CaseFold: true, StripPunctuation: true},
+			description: "WOOLWORTHS, Woolworths woolworths.",
+			expected:    []string{"woolworths", "woolworths", "woolworths"},
+		},
+		{
+			name:        "UnicodeCaseFold",
+			opts:        FeatureOptions{CaseFold: true},
+			description: "STRASSE Straße",
+			expected:    []string{"strasse", "strasse"},
+		},
+		{
+			name:        "MaskDigits",
+			opts:        FeatureOptions{MaskDigits: true},
+			description: "STORE1234 4321 A1B22",
+			expected:    []string{"STORE#", "A#B#"},
+		},
+		{
+			name:        "StopWords",
+			opts:        FeatureOptions{StopWords: []string{"pos", "VISA"}},
+			description: "POS WOOLWORTHS visa",
+			expected:    []string{"WOOLWORTHS"},
+		},
+		{
+			name:        "StripPatterns",
+			opts:        FeatureOptions{StripPatterns: []string{`\d{4}\*+\d{4}`, `\d{2}/\d{2}/\d{4}`}},
+			description: "COLES 1234****5678 01/02/2024",
+			expected:    []string{"COLES"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := newNormaliser(tt.opts)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, n.words(tt.description))
+		})
+	}
+
+	t.Run("InvalidPattern", func(t *testing.T) {
+		_, err := newNormaliser(FeatureOptions{StripPatterns: []string{"("}})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,198 @@
+package classifier
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/go-pkgz/lgr"
+)
+
+const (
+	BackendTags   = "tags" // backend name recorded in tag model file
+	minTagSamples = 2      // tags seen on fewer transactions are not learned
+	noTag         = ""     // class of transactions without tag in one-vs-rest models
+)
+
+// predicted tag with its probability
+type TagScore struct {
+	Tag         string  `json:"tag"`
+	Probability float64 `json:"probability"`
+}
+
+// multi-label tag classifier
+// every tag has its own multinomial model telling transactions
+// with the tag from the rest, so any number of tags can be predicted
+type TagClassifier struct {
+	model      *tagModel
+	logger     *lgr.Logger
+	normaliser *normaliser
+	mu         sync.RWMutex // guards model
+}
+
+// serializable tag model
+type tagModel struct {
+	Tags   []string
+	Models map[string]*multinomialModel // one-vs-rest model per tag
+}
+
+// init empty tag classifier
+func NewTagClassifier(opts FeatureOptions, l *lgr.Logger) (*TagClassifier, error) {
+	n, err := newNormaliser(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &TagClassifier{
+		model:      &tagModel{Models: make(map[string]*multinomialModel)},
+		logger:     l,
+		normaliser: n,
+	}, nil
+}
+
+// init tag classifier with training data set
+func NewTagClassifierWithTraining(dataSet TransactionDataSet, ignore []string, opts FeatureOptions, l *lgr.Logger) (*TagClassifier, error) {
+	tc, err := NewTagClassifier(opts, l)
+	if err != nil {
+		return nil, err
+	}
+	err = tc.Train(dataSet, ignore)
+	if err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// init tag classifier with model file
+// feature options recorded in model file are used
+func NewTagClassifierFromFile(modelFile string, l *lgr.Logger) (*TagClassifier, error) {
+	tc, err := NewTagClassifier(FeatureOptions{}, l)
+	if err != nil {
+		return nil, err
+	}
+	err = tc.Load(modelFile)
+	if err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// train model from scratch on tags of transactions in data set
+// ignored tags (e.g. the ones set by ffiiitc itself) are not learned
+func (tc *TagClassifier) Train(dataSet TransactionDataSet, ignore []string) error {
+	counts := make(map[string]int)
+	for _, trn := range dataSet {
+		for _, tag := range trn.Tags {
+			if !slices.Contains(ignore, tag) {
+				counts[tag]++
+			}
+		}
+	}
+	model := &tagModel{Models: make(map[string]*multinomialModel)}
+	for tag, count := range counts {
+		if count >= minTagSamples {
+			model.Tags = append(model.Tags, tag)
+			model.Models[tag] = newMultinomialModel()
+		}
+	}
+	if len(model.Tags) == 0 {
+		return fmt.Errorf("no tags used on at least %d transactions", minTagSamples)
+	}
+	sort.Strings(model.Tags)
+
+	for _, trn := range dataSet {
+		features := extractTransactionFeatures(trn, tc.normaliser)
+		for _, tag := range model.Tags {
+			class := noTag
+			if slices.Contains(trn.Tags, tag) {
+				class = tag
+			}
+			model.Models[tag].learn(class, features, 1)
+		}
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.model = model
+	return nil
+}
+
+// save tag model with its feature options to model file
+func (tc *TagClassifier) Save(modelFile string) error {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	var model bytes.Buffer
+	err := gob.NewEncoder(&model).Encode(tc.model)
+	if err != nil {
+		return err
+	}
+	return writeModelFile(modelFile, modelData{
+		Backend:  BackendTags,
+		Features: tc.normaliser.opts,
+		Model:    model.Bytes(),
+	})
+}
+
+// load tag model and its feature options from model file
+func (tc *TagClassifier) Load(modelFile string) error {
+	data, err := readBackendModelFile(modelFile, BackendTags)
+	if err != nil {
+		return err
+	}
+	n, err := newNormaliser(data.Features)
+	if err != nil {
+		return err
+	}
+	model := &tagModel{}
+	err = gob.NewDecoder(bytes.NewReader(data.Model)).Decode(model)
+	if err != nil {
+		return err
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.model = model
+	tc.normaliser = n
+	return nil
+}
+
+// get learned tags
+func (tc *TagClassifier) Tags() []string {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	return append([]string{}, tc.model.Tags...)
+}
+
+// probability of every learned tag, most likely first
+func (tc *TagClassifier) Predict(t Transaction) []TagScore {
+	features := extractTransactionFeatures(t, tc.normaliser)
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	scores := make([]TagScore, 0, len(tc.model.Tags))
+	for _, tag := range tc.model.Tags {
+		model := tc.model.Models[tag]
+		probs := logScoresToProbabilities(model.logScores(features))
+		prob := 0.0
+		for i, class := range model.Categories {
+			if class == tag {
+				prob = probs[i]
+			}
+		}
+		scores = append(scores, TagScore{Tag: tag, Probability: prob})
+	}
+	sort.SliceStable(scores, func(a, b int) bool {
+		return scores[a].Probability > scores[b].Probability
+	})
+	return scores
+}
+
+// predict tags with probability of at least threshold
+func (tc *TagClassifier) PredictTags(t Transaction, threshold float64) []string {
+	var tags []string
+	for _, score := range tc.Predict(t) {
+		if score.Probability >= threshold {
+			tags = append(tags, score.Tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,58 @@
+package classifier
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-pkgz/lgr"
+	"github.com/stretchr/testify/assert"
+)
+
+var testTagDataSet = TransactionDataSet{
+	{Description: "NETFLIX.COM", Tags: []string{"subscription", "ffiiitc"}},
+	{Description: "SPOTIFY PREMIUM", Tags: []string{"subscription"}},
+	{Description: "NETFLIX.COM MONTHLY", Tags: []string{"subscription"}},
+	{Description: "QANTAS AIRWAYS", Tags: []string{"holiday", "reimbursable"}},
+	{Description: "HILTON HOTELS", Tags: []string{"holiday", "reimbursable"}},
+	{Description: "WOOLWORTHS METRO", Tags: []string{"ffiiitc"}},
+	{Description: "COLES SUPERMARKET"},
+	{Description: "UBER TRIP", Tags: []string{"once"}},
+}
+
+func TestTagClassifier(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	tc, err := NewTagClassifierWithTraining(testTagDataSet, []string{"ffiiitc"}, FeatureOptions{CaseFold: true}, logger)
+	assert.NoError(t, err)
+
+	// ignored and rare tags are not learned
+	assert.Equal(t, []string{"holiday", "reimbursable", "subscription"}, tc.Tags())
+
+	assert.Equal(t, []string{"subscription"}, tc.PredictTags(Transaction{Description: "netflix.com"}, 0.5))
+	assert.ElementsMatch(t, []string{"holiday", "reimbursable"}, tc.PredictTags(Transaction{Description: "QANTAS AIRWAYS"}, 0.5))
+	assert.Empty(t, tc.PredictTags(Transaction{Description: "COLES SUPERMARKET"}, 0.5))
+
+	scores := tc.Predict(Transaction{Description: "SPOTIFY"})
+	assert.Len(t, scores, 3)
+	assert.Equal(t, "subscription", scores[0].Tag)
+
+	// model and feature options are saved to file
+	modelFile := filepath.Join(t.TempDir(), "tags.gob")
+	assert.NoError(t, tc.Save(modelFile))
+	loaded, err := NewTagClassifierFromFile(modelFile, logger)
+	assert.NoError(t, err)
+	assert.Equal(t, tc.Tags(), loaded.Tags())
+	assert.Equal(t, tc.Predict(Transaction{Description: "netflix"}), loaded.Predict(Transaction{Description: "netflix"}))
+
+	// classifier model file is not a tag model
+	cls, err := NewClassifierWithTraining(BackendMultinomial, testDataSet, FeatureOptions{}, logger)
+	assert.NoError(t, err)
+	assert.NoError(t, cls.Save(modelFile))
+	_, err = NewTagClassifierFromFile(modelFile, logger)
+	assert.Error(t, err)
+}
+
+func TestTagClassifierNoTags(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	_, err := NewTagClassifierWithTraining(testDataSet, nil, FeatureOptions{}, logger)
+	assert.Error(t, err)
+}
@@ -0,0 +1,206 @@
+package classifier
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// prior strategies, i.e. how likely category is before
+// anything is known about transaction
+const (
+	PriorLearned  = "learned"  // share of category in training data
+	PriorUniform  = "uniform"  // all categories are equally likely
+	PriorSmoothed = "smoothed" // square root of learned share, big categories are favoured less
+)
+
+var Priors = []string{PriorLearned, PriorUniform, PriorSmoothed}
+
+// bayesian model keeps whole counts, so weighted feature counts
+// are scaled before rounding to keep small weights apart
+const weightScale = 100
+
+// training settings, recorded in model file with feature options
+// as priors are also applied when transactions are classified
+type TrainingOptions struct {
+	Prior      string `json:"prior,omitempty"`       // one of Priors, learned if empty
+	MaxSamples int    `json:"max_samples,omitempty"` // max transactions per category, latest are kept, 0 for no cap
+	Balance    bool   `json:"balance,omitempty"`     // weight transactions inversely to size of their category
+	// age of transactions is counted back from the latest one
+	HalfLifeDays int `json:"half_life_days,omitempty"` // weight of transaction halves with every half life, 0 for no decay
+	MaxAgeDays   int `json:"max_age_days,omitempty"`   // older transactions are not learned, 0 for no limit
+}
+
+func (o TrainingOptions) String() string {
+	prior := o.Prior
+	if prior == "" {
+		prior = PriorLearned
+	}
+	msg := "prior: " + prior
+	if o.MaxSamples > 0 {
+		msg += fmt.Sprintf(", max samples per category: %d", o.MaxSamples)
+	}
+	if o.Balance {
+		msg += ", balanced categories"
+	}
+	if o.HalfLifeDays > 0 {
+		msg += fmt.Sprintf(", half life: %d days", o.HalfLifeDays)
+	}
+	if o.MaxAgeDays > 0 {
+		msg += fmt.Sprintf(", max age: %d days", o.MaxAgeDays)
+	}
+	return msg
+}
+
+// check if transactions are weighted in training
+func (o TrainingOptions) weighted() bool {
+	return o.Balance || o.HalfLifeDays > 0
+}
+
+// count of feature learned from single transaction by bayesian model
+// weighted models are scaled, so online learning has to add the same
+func (o TrainingOptions) countScale() int {
+	if o.weighted() {
+		return weightScale
+	}
+	return 1
+}
+
+// exponent learned priors are raised to
+func (o TrainingOptions) priorExponent() float64 {
+	switch o.Prior {
+	case PriorUniform:
+		return 0
+	case PriorSmoothed:
+		return 0.5
+	default:
+		return 1
+	}
+}
+
+// replace learned priors in log scores with priors of strategy
+// log scores of backends include log of learned prior, so it is
+// scaled by exponent, normalisation constant does not change ranking
+func (o TrainingOptions) adjustLogScores(logScores, learnedPriors []float64) []float64 {
+	exp := o.priorExponent()
+	if exp == 1 {
+		return logScores
+	}
+	res := make([]float64, len(logScores))
+	for i, score := range logScores {
+		res[i] = score
+		if learnedPriors[i] > 0 {
+			res[i] -= (1 - exp) * math.Log(learnedPriors[i])
+		}
+	}
+	return res
+}
+
+// drop too old transactions, cap transactions per category and
+// get weight of every transaction
+// weights are nil if transactions are not weighted, otherwise
+// they average to 1 so that models stay comparable with online learning
+func prepareTrainingSet(dataSet TransactionDataSet, opts TrainingOptions) (TransactionDataSet, []float64) {
+	latest := latestDate(dataSet)
+	if opts.MaxAgeDays > 0 {
+		dataSet = dropOlder(dataSet, latest.AddDate(0, 0, -opts.MaxAgeDays))
+	}
+	if opts.MaxSamples > 0 {
+		dataSet = capCategories(dataSet, opts.MaxSamples)
+	}
+	if !opts.weighted() || len(dataSet) == 0 {
+		return dataSet, nil
+	}
+
+	weights := make([]float64, len(dataSet))
+	for i := range weights {
+		weights[i] = 1
+	}
+	if opts.Balance {
+		counts := make(map[string]int)
+		for _, trn := range dataSet {
+			counts[trn.Category]++
+		}
+		for i, trn := range dataSet {
+			weights[i] *= float64(len(dataSet)) / float64(len(counts)*counts[trn.Category])
+		}
+	}
+	// transactions without date are as recent as the latest one
+	if opts.HalfLifeDays > 0 {
+		halfLife := float64(opts.HalfLifeDays) * 24
+		for i, trn := range dataSet {
+			if !trn.Date.IsZero() {
+				weights[i] *= math.Pow(0.5, latest.Sub(trn.Date).Hours()/halfLife)
+			}
+		}
+	}
+
+	sum := 0.0
+	for _, w := range weights {
+		sum += w
+	}
+	for i := range weights {
+		weights[i] *= float64(len(weights)) / sum
+	}
+	return dataSet, weights
+}
+
+// get date of the latest transaction, zero if there are no dates
+func latestDate(dataSet TransactionDataSet) time.Time {
+	var latest time.Time
+	for _, trn := range dataSet {
+		if trn.Date.After(latest) {
+			latest = trn.Date
+		}
+	}
+	return latest
+}
+
+// drop transactions before date
+// transactions without date are kept
+func dropOlder(dataSet TransactionDataSet, date time.Time) TransactionDataSet {
+	res := TransactionDataSet{}
+	for _, trn := range dataSet {
+		if trn.Date.IsZero() || !trn.Date.Before(date) {
+			res = append(res, trn)
+		}
+	}
+	return res
+}
+
+// keep at most maxSamples latest transactions of every category
+// order of transactions is kept
+func capCategories(dataSet TransactionDataSet, maxSamples int) TransactionDataSet {
+	byCategory := make(map[string][]int)
+	for i, trn := range dataSet {
+		byCategory[trn.Category] = append(byCategory[trn.Category], i)
+	}
+	keep := make([]bool, len(dataSet))
+	for _, indices := range byCategory {
+		sort.SliceStable(indices, func(a, b int) bool {
+			return dataSet[indices[a]].Date.After(dataSet[indices[b]].Date)
+		})
+		if len(indices) > maxSamples {
+			indices = indices[:maxSamples]
+		}
+		for _, i := range indices {
+			keep[i] = true
+		}
+	}
+	res := TransactionDataSet{}
+	for i, trn := range dataSet {
+		if keep[i] {
+			res = append(res, trn)
+		}
+	}
+	return res
+}
+
+// get weight of transaction, 1 if transactions are not weighted
+func weightAt(weights []float64, i int) float64 {
+	if weights == nil {
+		return 1
+	}
+	return weights[i]
+}
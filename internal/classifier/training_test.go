@@ -0,0 +1,193 @@
+package classifier
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/lgr"
+	"github.com/stretchr/testify/assert"
+)
+
+// nine groceries for one pet transaction
+func imbalancedDataSet() TransactionDataSet {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var data TransactionDataSet
+	for i := 0; i < 9; i++ {
+		data = append(data, Transaction{Category: "Groceries", Description: "WOOLWORTHS METRO", Date: start.AddDate(0, 0, i)})
+	}
+	return append(data, Transaction{Category: "Pets", Description: "PETBARN STORE", Date: start})
+}
+
+func TestPriors(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	for _, backend := range Backends() {
+		t.Run(backend, func(t *testing.T) {
+			probability := func(prior string) float64 {
+				cls, err := NewClassifierWithTraining(backend, imbalancedDataSet(), FeatureOptions{Training: TrainingOptions{Prior: prior}}, logger)
+				assert.NoError(t, err)
+				// nothing is known about transaction, so only prior matters
+				for _, score := range cls.Predict(Transaction{Description: "UNKNOWN SHOP"}).Scores {
+					if score.Category == "Pets" {
+						return score.Probability
+					}
+				}
+				return 0
+			}
+			learned := probability(PriorLearned)
+			assert.Less(t, learned, 0.5)
+			assert.Equal(t, learned, probability(""))
+			assert.InDelta(t, 0.5, probability(PriorUniform), 0.000001)
+			smoothed := probability(PriorSmoothed)
+			assert.Greater(t, smoothed, learned)
+			assert.Less(t, smoothed, 0.5)
+			// smoothed prior is square root of learned one
+			assert.InDelta(t, math.Sqrt(learned/(1-learned)), smoothed/(1-smoothed), 0.000001)
+		})
+	}
+}
+
+func TestPriorSavedWithModel(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	opts := FeatureOptions{Training: TrainingOptions{Prior: PriorUniform, MaxSamples: 5, Balance: true}}
+	cls, err := NewClassifierWithTraining(BackendBayesian, imbalancedDataSet(), opts, logger)
+	assert.NoError(t, err)
+	modelFile := filepath.Join(t.TempDir(), "model.gob")
+	assert.NoError(t, cls.Save(modelFile))
+
+	loaded, err := NewClassifierFromFile(modelFile, logger)
+	assert.NoError(t, err)
+	assert.Equal(t, opts, loaded.Describe().Features)
+	trn := Transaction{Description: "UNKNOWN SHOP"}
+	assert.Equal(t, cls.Predict(trn), loaded.Predict(trn))
+}
+
+func TestPrepareTrainingSet(t *testing.T) {
+	data, weights := prepareTrainingSet(imbalancedDataSet(), TrainingOptions{})
+	assert.Len(t, data, 10)
+	assert.Nil(t, weights)
+
+	// latest groceries are kept
+	data, weights = prepareTrainingSet(imbalancedDataSet(), TrainingOptions{MaxSamples: 3})
+	assert.Nil(t, weights)
+	if assert.Len(t, data, 4) {
+		assert.Equal(t, []int{6, 7, 8}, []int{data[0].Date.Day() - 1, data[1].Date.Day() - 1, data[2].Date.Day() - 1})
+		assert.Equal(t, "Pets", data[3].Category)
+	}
+
+	// both categories weigh the same and weights average to 1
+	data, weights = prepareTrainingSet(imbalancedDataSet(), TrainingOptions{Balance: true})
+	assert.Len(t, data, 10)
+	assert.InDelta(t, 10.0/18, weights[0], 0.000001)
+	assert.InDelta(t, 5.0, weights[9], 0.000001)
+	sum := 0.0
+	for _, w := range weights {
+		sum += w
+	}
+	assert.InDelta(t, 10.0, sum, 0.000001)
+}
+
+func TestBalancedTraining(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	opts := FeatureOptions{Training: TrainingOptions{Balance: true}}
+	for _, backend := range Backends() {
+		cls, err := NewClassifierWithTraining(backend, imbalancedDataSet(), opts, logger)
+		assert.NoError(t, err)
+		assert.Equal(t, "Groceries", cls.Predict(Transaction{Description: "WOOLWORTHS"}).Category, backend)
+		assert.Equal(t, "Pets", cls.Predict(Transaction{Description: "PETBARN"}).Category, backend)
+	}
+
+	// receipts of big category mostly have features seen once,
+	// they must not outweigh small category after balancing
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var data TransactionDataSet
+	for i := 0; i < 300; i++ {
+		data = append(data, Transaction{Category: "Groceries", Description: fmt.Sprintf("WOOLWORTHS S%03d", i), Date: start})
+	}
+	for i := 0; i < 10; i++ {
+		data = append(data, Transaction{Category: "Pets", Description: fmt.Sprintf("PETBARN P%03d", i), Date: start})
+	}
+	for _, backend := range Backends() {
+		cls, err := NewClassifierWithTraining(backend, data, opts, logger)
+		assert.NoError(t, err)
+		// nothing is known about transaction, so both categories are equally likely
+		assert.InDelta(t, 0.5, cls.Predict(Transaction{Description: "UNKNOWN SHOP"}).Probability, 0.02, backend)
+	}
+}
+
+// merchant moved from work lunch to groceries a year ago
+func changedHabitsDataSet() TransactionDataSet {
+	latest := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	var data TransactionDataSet
+	for i := 0; i < 3; i++ {
+		data = append(data, Transaction{Category: "Groceries", Description: "CORNER DELI", Date: latest.AddDate(0, -i, 0)})
+	}
+	for i := 0; i < 6; i++ {
+		data = append(data, Transaction{Category: "Work lunch", Description: "CORNER DELI", Date: latest.AddDate(-2, -i, 0)})
+	}
+	return append(data,
+		Transaction{Category: "Groceries", Description: "WOOLWORTHS", Date: latest},
+		Transaction{Category: "Work lunch", Description: "SUSHI TRAIN", Date: latest.AddDate(-2, 0, 0)},
+	)
+}
+
+func TestRecencyWeighting(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	trn := Transaction{Description: "CORNER DELI"}
+	for _, backend := range Backends() {
+		cls, err := NewClassifierWithTraining(backend, changedHabitsDataSet(), FeatureOptions{}, logger)
+		assert.NoError(t, err)
+		assert.Equal(t, "Work lunch", cls.Predict(trn).Category, backend)
+
+		cls, err = NewClassifierWithTraining(backend, changedHabitsDataSet(), FeatureOptions{Training: TrainingOptions{HalfLifeDays: 180}}, logger)
+		assert.NoError(t, err)
+		assert.Equal(t, "Groceries", cls.Predict(trn).Category, backend)
+	}
+
+	// merchant seen among many others, with default half life
+	latest := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	mixed := TransactionDataSet{{Category: "Groceries", Description: "CORNER DELI", Date: latest}}
+	for i := 0; i < 2; i++ {
+		mixed = append(mixed, Transaction{Category: "Work lunch", Description: "CORNER DELI", Date: latest.AddDate(-3, -i, 0)})
+	}
+	merchants := []struct{ category, description string }{
+		{"Groceries", "WOOLWORTHS METRO"},
+		{"Groceries", "COLES SUPERMARKET"},
+		{"Work lunch", "SUSHI TRAIN"},
+		{"Transport", "UBER TRIP"},
+		{"Utilities", "AGL ENERGY"},
+	}
+	for i := 0; i < 100; i++ {
+		m := merchants[i%len(merchants)]
+		mixed = append(mixed, Transaction{Category: m.category, Description: fmt.Sprintf("%s REF%d", m.description, i), Date: latest.AddDate(0, 0, -3*i)})
+	}
+	for _, backend := range Backends() {
+		cls, err := NewClassifierWithTraining(backend, mixed, FeatureOptions{Training: TrainingOptions{HalfLifeDays: 365}}, logger)
+		assert.NoError(t, err)
+		pred := cls.Predict(trn)
+		assert.Equal(t, "Groceries", pred.Category, backend)
+		assert.Greater(t, pred.Probability, 0.6, backend)
+	}
+
+	// recent transactions weigh more, weights still average to 1
+	data, weights := prepareTrainingSet(changedHabitsDataSet(), TrainingOptions{HalfLifeDays: 365})
+	assert.Len(t, data, 11)
+	assert.Greater(t, weights[0], 1.0)
+	assert.Equal(t, weights[0], weights[9])
+	// two years older transaction weighs a quarter
+	assert.InDelta(t, 0.25, weights[3]/weights[0], 0.001)
+	sum := 0.0
+	for _, w := range weights {
+		sum += w
+	}
+	assert.InDelta(t, 11.0, sum, 0.000001)
+
+	// old transactions are not learned at all
+	data, _ = prepareTrainingSet(changedHabitsDataSet(), TrainingOptions{MaxAgeDays: 365})
+	assert.Len(t, data, 4)
+	for _, trn := range data {
+		assert.Equal(t, "Groceries", trn.Category)
+	}
+}
@@ -2,23 +2,145 @@ package config
 
 import (
 	"errors"
+	"ffiiitc/internal/classifier"
+	"ffiiitc/internal/firefly"
 	"fmt"
+	"math"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/go-pkgz/lgr"
 )
 
 const (
-	FireflyAppTimeout = 10               // 10 sec for fftc to app service timeout
-	ModelFile         = "data/model.gob" //file name to store model
-	apiKeyEnvVar      = "FF_API_KEY"
-	appUrlEnvVar      = "FF_APP_URL"
+	FireflyAppTimeout   = 10                   // 10 sec for fftc to app service timeout
+	ModelFile           = "data/model.gob"     //file name to store model
+	TagModelFile        = "data/tags.gob"      // file name to store tag model
+	BudgetModelFile     = "data/budgets.gob"   // file name to store budget model
+	BackfillStateFile   = "data/backfill.json" // file name to store backfill progress
+	LearnedStateFile    = "data/learned.json"  // file name to store categories learned or set by ffiiitc
+	apiKeyEnvVar        = "FF_API_KEY"
+	appUrlEnvVar        = "FF_APP_URL"
+	minConfidenceEnvVar = "FF_MIN_CONFIDENCE"
+	reviewTagEnvVar     = "FF_REVIEW_TAG"
+	webhookSecretEnvVar = "FF_WEBHOOK_SECRET"
+	learnSecretEnvVar   = "FF_LEARN_WEBHOOK_SECRET"
+	webhookTolEnvVar    = "FF_WEBHOOK_TOLERANCE"
+	signatureOffEnvVar  = "FF_WEBHOOK_SIGNATURE_DISABLED"
+	defaultWebhookTol   = 300 // 5 min for webhook signature timestamp tolerance
+	adminTokenEnvVar    = "FF_ADMIN_TOKEN"
+	adminUserEnvVar     = "FF_ADMIN_USER"
+	adminPassEnvVar     = "FF_ADMIN_PASSWORD"
+	adminDisabledEnvVar = "FF_ADMIN_AUTH_DISABLED"
+	caseFoldEnvVar      = "FF_CASE_FOLD"
+	stripPunctEnvVar    = "FF_STRIP_PUNCTUATION"
+	maskDigitsEnvVar    = "FF_MASK_DIGITS"
+	stopWordsEnvVar     = "FF_STOP_WORDS"
+	stripPatternsEnvVar = "FF_STRIP_PATTERNS"
+	charNGramsEnvVar    = "FF_CHAR_NGRAMS"
+	wordBigramsEnvVar   = "FF_WORD_BIGRAMS"
+	maxCharNGrams       = 10
+	backendEnvVar       = "FF_CLASSIFIER_BACKEND"
+	rulesPathEnvVar     = "FF_RULES_PATH"
+	tagsEnabledEnvVar   = "FF_TAGS_ENABLED"
+	tagThresholdEnvVar  = "FF_TAG_THRESHOLD"
+	defaultTagThreshold = 0.8
+	categoriesEnvVar    = "FF_CATEGORIES_ENABLED"
+	budgetsEnvVar       = "FF_BUDGETS_ENABLED"
+	budgetMinConfEnvVar = "FF_BUDGET_MIN_CONFIDENCE"
+	overwriteEnvVar     = "FF_OVERWRITE_POLICY"
+	excludedTypesEnvVar = "FF_TRAINING_EXCLUDED_TYPES"
+	ignoredCatsEnvVar   = "FF_IGNORED_CATEGORIES"
+	minSamplesEnvVar    = "FF_MIN_CATEGORY_SAMPLES"
+	defaultMinSamples   = 2
+	mergeSmallEnvVar    = "FF_MERGE_SMALL_CATEGORIES"
+	priorEnvVar         = "FF_PRIOR"
+	maxSamplesEnvVar    = "FF_MAX_CATEGORY_SAMPLES"
+	balanceEnvVar       = "FF_BALANCE_CATEGORIES"
+	halfLifeEnvVar      = "FF_HALF_LIFE_DAYS"
+	defaultHalfLife     = 365 // transactions a year older than the latest one weigh half as much
+	maxAgeEnvVar        = "FF_MAX_AGE_DAYS"
+	suggestModeEnvVar   = "FF_SUGGEST_MODE"
+	suggestTagEnvVar    = "FF_SUGGEST_TAG"
+	DefaultSuggestTag   = "ffiiitc-suggested"
 )
 
+// transaction types not used for training by default,
+// they have no meaningful category or poison the model
+var DefaultExcludedTypes = []string{firefly.TypeTransfer, firefly.TypeOpeningBalance, firefly.TypeReconciliation}
+
+// policies for category and budget already set on new transaction
+const (
+	OverwriteOnlyEmpty       = "only-empty"        // never overwrite
+	OverwriteAlways          = "always"            // always overwrite
+	OverwriteIfMoreConfident = "if-more-confident" // overwrite if prediction is more likely than existing value
+)
+
+var overwritePolicies = []string{OverwriteOnlyEmpty, OverwriteAlways, OverwriteIfMoreConfident}
+
+// modes of suggesting categories instead of setting them on new transaction
+const (
+	SuggestOff           = "off"            // predicted category is set
+	SuggestAlways        = "always"         // predicted category is never set, only suggested
+	SuggestLowConfidence = "low-confidence" // category below MinConfidence is suggested
+)
+
+var suggestModes = []string{SuggestOff, SuggestAlways, SuggestLowConfidence}
+
 type Config struct {
-	APIKey string
-	FFApp  string
+	APIKey        string
+	FFApp         string
+	MinConfidence float64 // classification below this probability is not applied
+	ReviewTag     string  // tag for transactions classified below MinConfidence
+	// webhook secrets, requests are rejected if empty
+	// unless signature check is explicitly disabled
+	WebhookSecret            string
+	LearnWebhookSecret       string
+	WebhookTolerance         int // seconds
+	WebhookSignatureDisabled bool
+	// credentials for admin endpoints, they are rejected if empty
+	// unless admin auth is explicitly disabled
+	AdminToken        string
+	AdminUser         string
+	AdminPassword     string
+	AdminAuthDisabled bool
+	// classifier backend and feature extraction settings used for training
+	Backend  string
+	Features classifier.FeatureOptions
+	// yaml or json file with classification rules, no rules if empty
+	RulesPath string
+	// tag prediction, tags below threshold are not applied
+	TagsEnabled  bool
+	TagThreshold float64
+	// categories and budgets of new transactions are set only if enabled,
+	// and like with MinConfidence, budget below threshold is not applied
+	CategoriesEnabled   bool
+	BudgetsEnabled      bool
+	BudgetMinConfidence float64
+	OverwritePolicy     string // one of Overwrite* policies
+	// top categories are written to notes instead of setting predicted one,
+	// and transaction is tagged with SuggestTag if it is not empty
+	SuggestMode string // one of Suggest* modes
+	SuggestTag  string
+	// transaction types left out of training and not classified
+	TrainingExcludedTypes []string
+	// categories kept in training data set
+	Validation classifier.ValidationOptions
+}
+
+// tags that are not learned by tag classifier
+// as they are set by ffiiitc itself
+func (c *Config) IgnoredTags() []string {
+	tags := []string{firefly.ClassifiedTag}
+	if c.ReviewTag != "" {
+		tags = append(tags, c.ReviewTag)
+	}
+	if c.SuggestTag != "" {
+		tags = append(tags, c.SuggestTag)
+	}
+	return tags
 }
 
 var envVars = []string{
@@ -69,6 +191,65 @@ func LookupEnvVar(variableName string, logger *lgr.Logger) (string, bool) {
 	return os.LookupEnv(variableName)
 }
 
+// get optional number from env var
+// returns default value if var is not set
+// and error if value is not a number in [min, max] range
+func LookupFloatEnvVar(variableName string, defaultValue, min, max float64, logger *lgr.Logger) (float64, error) {
+	valueStr, exists := LookupEnvVar(variableName, logger)
+	if !exists || valueStr == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil || value < min || value > max {
+		return 0, fmt.Errorf("Environment var '%s' must be number between %v and %v, got '%s'", variableName, min, max, valueStr)
+	}
+	return value, nil
+}
+
+// get optional integer from env var
+// returns default value if var is not set
+// and error if value is not an integer in [min, max] range
+func LookupIntEnvVar(variableName string, defaultValue, min, max int, logger *lgr.Logger) (int, error) {
+	valueStr, exists := LookupEnvVar(variableName, logger)
+	if !exists || valueStr == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(valueStr)
+	if err != nil || value < min || value > max {
+		return 0, fmt.Errorf("Environment var '%s' must be integer between %d and %d, got '%s'", variableName, min, max, valueStr)
+	}
+	return value, nil
+}
+
+// get optional boolean from env var
+// returns default value if var is not set
+func LookupBoolEnvVar(variableName string, defaultValue bool, logger *lgr.Logger) (bool, error) {
+	valueStr, exists := LookupEnvVar(variableName, logger)
+	if !exists || valueStr == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		return false, fmt.Errorf("Environment var '%s' must be true or false, got '%s'", variableName, valueStr)
+	}
+	return value, nil
+}
+
+// split list value by any of separators
+// items are trimmed and empty ones dropped
+func SplitList(value, separators string) []string {
+	var res []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool {
+		return strings.ContainsRune(separators, r)
+	}) {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
 func FormatEnvNotSetErrorMessage(variableName string) string {
 	return fmt.Sprintf("Environment vars '%s' or '%s' not set!", variableName, variableName+"_FILE")
 }
@@ -85,9 +266,186 @@ func NewConfig(logger *lgr.Logger) (*Config, error) {
 		return nil, errors.New(FormatEnvNotSetErrorMessage(appUrlEnvVar))
 	}
 
+	minConfidence, err := LookupFloatEnvVar(minConfidenceEnvVar, 0, 0, 1, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	reviewTag, _ := LookupEnvVar(reviewTagEnvVar, logger)
+
+	webhookSecret, _ := LookupEnvVar(webhookSecretEnvVar, logger)
+	learnSecret, _ := LookupEnvVar(learnSecretEnvVar, logger)
+	webhookTolerance, err := LookupIntEnvVar(webhookTolEnvVar, defaultWebhookTol, 0, math.MaxInt32, logger)
+	if err != nil {
+		return nil, err
+	}
+	signatureDisabled, err := LookupBoolEnvVar(signatureOffEnvVar, false, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	adminToken, _ := LookupEnvVar(adminTokenEnvVar, logger)
+	adminUser, _ := LookupEnvVar(adminUserEnvVar, logger)
+	adminPassword, _ := LookupEnvVar(adminPassEnvVar, logger)
+	if adminUser != "" && adminPassword == "" {
+		return nil, errors.New(FormatEnvNotSetErrorMessage(adminPassEnvVar))
+	}
+	adminAuthDisabled, err := LookupBoolEnvVar(adminDisabledEnvVar, false, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	var features classifier.FeatureOptions
+	for name, value := range map[string]*bool{
+		caseFoldEnvVar:    &features.CaseFold,
+		stripPunctEnvVar:  &features.StripPunctuation,
+		maskDigitsEnvVar:  &features.MaskDigits,
+		wordBigramsEnvVar: &features.WordBigrams,
+	} {
+		*value, err = LookupBoolEnvVar(name, false, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// stop words are separated by commas or new lines, patterns by new lines only
+	stopWords, _ := LookupEnvVar(stopWordsEnvVar, logger)
+	features.StopWords = SplitList(stopWords, ",\n")
+	stripPatterns, _ := LookupEnvVar(stripPatternsEnvVar, logger)
+	features.StripPatterns = SplitList(stripPatterns, "\n")
+	features.CharNGrams, err = LookupIntEnvVar(charNGramsEnvVar, 0, 0, maxCharNGrams, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// empty prior is learned one, like in models trained before priors were introduced
+	features.Training.Prior, _ = LookupEnvVar(priorEnvVar, logger)
+	if features.Training.Prior != "" && !slices.Contains(classifier.Priors, features.Training.Prior) {
+		return nil, fmt.Errorf("Environment var '%s' must be one of %v, got '%s'", priorEnvVar, classifier.Priors, features.Training.Prior)
+	}
+	features.Training.MaxSamples, err = LookupIntEnvVar(maxSamplesEnvVar, 0, 0, math.MaxInt32, logger)
+	if err != nil {
+		return nil, err
+	}
+	features.Training.Balance, err = LookupBoolEnvVar(balanceEnvVar, false, logger)
+	if err != nil {
+		return nil, err
+	}
+	features.Training.HalfLifeDays, err = LookupIntEnvVar(halfLifeEnvVar, defaultHalfLife, 0, math.MaxInt32, logger)
+	if err != nil {
+		return nil, err
+	}
+	features.Training.MaxAgeDays, err = LookupIntEnvVar(maxAgeEnvVar, 0, 0, math.MaxInt32, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	backend, _ := LookupEnvVar(backendEnvVar, logger)
+	if backend == "" {
+		backend = classifier.BackendBayesian
+	}
+	if !slices.Contains(classifier.Backends(), backend) {
+		return nil, fmt.Errorf("Environment var '%s' must be one of %v, got '%s'", backendEnvVar, classifier.Backends(), backend)
+	}
+
+	overwritePolicy, _ := LookupEnvVar(overwriteEnvVar, logger)
+	if overwritePolicy == "" {
+		overwritePolicy = OverwriteOnlyEmpty
+	}
+	if !slices.Contains(overwritePolicies, overwritePolicy) {
+		return nil, fmt.Errorf("Environment var '%s' must be one of %v, got '%s'", overwriteEnvVar, overwritePolicies, overwritePolicy)
+	}
+
+	suggestMode, _ := LookupEnvVar(suggestModeEnvVar, logger)
+	if suggestMode == "" {
+		suggestMode = SuggestOff
+	}
+	if !slices.Contains(suggestModes, suggestMode) {
+		return nil, fmt.Errorf("Environment var '%s' must be one of %v, got '%s'", suggestModeEnvVar, suggestModes, suggestMode)
+	}
+	// empty value disables tag
+	suggestTag, exists := LookupEnvVar(suggestTagEnvVar, logger)
+	if !exists {
+		suggestTag = DefaultSuggestTag
+	}
+
+	// empty value excludes nothing
+	excludedTypes := DefaultExcludedTypes
+	if excludedTypesStr, exists := LookupEnvVar(excludedTypesEnvVar, logger); exists {
+		excludedTypes = SplitList(strings.ToLower(excludedTypesStr), ",")
+	}
+	for _, t := range excludedTypes {
+		if !slices.Contains(firefly.TransactionTypes, t) {
+			return nil, fmt.Errorf("Environment var '%s' must be list of %v, got '%s'", excludedTypesEnvVar, firefly.TransactionTypes, t)
+		}
+	}
+
+	var validation classifier.ValidationOptions
+	ignoredCategories, _ := LookupEnvVar(ignoredCatsEnvVar, logger)
+	validation.IgnoredCategories = SplitList(ignoredCategories, ",\n")
+	validation.MinSamples, err = LookupIntEnvVar(minSamplesEnvVar, defaultMinSamples, 1, math.MaxInt32, logger)
+	if err != nil {
+		return nil, err
+	}
+	mergeInto, _ := LookupEnvVar(mergeSmallEnvVar, logger)
+	validation.MergeInto = strings.TrimSpace(mergeInto)
+
+	rulesPath, _ := LookupEnvVar(rulesPathEnvVar, logger)
+
+	tagsEnabled, err := LookupBoolEnvVar(tagsEnabledEnvVar, false, logger)
+	if err != nil {
+		return nil, err
+	}
+	tagThreshold, err := LookupFloatEnvVar(tagThresholdEnvVar, defaultTagThreshold, 0, 1, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	categoriesEnabled, err := LookupBoolEnvVar(categoriesEnvVar, true, logger)
+	if err != nil {
+		return nil, err
+	}
+	budgetsEnabled, err := LookupBoolEnvVar(budgetsEnvVar, false, logger)
+	if err != nil {
+		return nil, err
+	}
+	budgetMinConfidence, err := LookupFloatEnvVar(budgetMinConfEnvVar, 0, 0, 1, logger)
+	if err != nil {
+		return nil, err
+	}
+
 	cfg := Config{
-		APIKey: apiKey,
-		FFApp:  appUrl,
+		APIKey:        apiKey,
+		FFApp:         appUrl,
+		MinConfidence: minConfidence,
+		ReviewTag:     reviewTag,
+
+		WebhookSecret:            webhookSecret,
+		LearnWebhookSecret:       learnSecret,
+		WebhookTolerance:         webhookTolerance,
+		WebhookSignatureDisabled: signatureDisabled,
+
+		AdminToken:        adminToken,
+		AdminUser:         adminUser,
+		AdminPassword:     adminPassword,
+		AdminAuthDisabled: adminAuthDisabled,
+
+		Backend:  backend,
+		Features: features,
+
+		RulesPath: rulesPath,
+
+		TagsEnabled:  tagsEnabled,
+		TagThreshold: tagThreshold,
+
+		CategoriesEnabled:   categoriesEnabled,
+		BudgetsEnabled:      budgetsEnabled,
+		BudgetMinConfidence: budgetMinConfidence,
+		OverwritePolicy:     overwritePolicy,
+		SuggestMode:         suggestMode,
+		SuggestTag:          suggestTag,
+
+		TrainingExcludedTypes: excludedTypes,
+		Validation:            validation,
 	}
 
 	return &cfg, nil
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"ffiiitc/internal/classifier"
 	"os"
+	"slices"
 	"testing"
 
 	"github.com/go-pkgz/lgr"
@@ -74,3 +76,173 @@ func TestNewConfig(t *testing.T) {
 		}
 	})
 }
+
+func TestLookupFloatEnvVar(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+
+	t.Run("NotSet", func(t *testing.T) {
+		value, err := LookupFloatEnvVar("TEST_FLOAT_VAR", 0.5, 0, 1, logger)
+		if err != nil {
+			t.Errorf("Expected no error, but got: %v", err)
+		}
+		if value != 0.5 {
+			t.Errorf("Expected default value 0.5, but got: %v", value)
+		}
+	})
+
+	t.Run("ValidValue", func(t *testing.T) {
+		os.Setenv("TEST_FLOAT_VAR", "0.7")
+		defer os.Unsetenv("TEST_FLOAT_VAR")
+
+		value, err := LookupFloatEnvVar("TEST_FLOAT_VAR", 0, 0, 1, logger)
+		if err != nil {
+			t.Errorf("Expected no error, but got: %v", err)
+		}
+		if value != 0.7 {
+			t.Errorf("Expected value 0.7, but got: %v", value)
+		}
+	})
+
+	t.Run("InvalidValue", func(t *testing.T) {
+		for _, invalid := range []string{"abc", "1.5", "-1"} {
+			os.Setenv("TEST_FLOAT_VAR", invalid)
+			_, err := LookupFloatEnvVar("TEST_FLOAT_VAR", 0, 0, 1, logger)
+			if err == nil {
+				t.Errorf("Expected error for value '%s', but got no error", invalid)
+			}
+		}
+		os.Unsetenv("TEST_FLOAT_VAR")
+	})
+}
+
+func TestLookupBoolEnvVar(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+
+	os.Setenv("TEST_BOOL_VAR", "true")
+	value, err := LookupBoolEnvVar("TEST_BOOL_VAR", false, logger)
+	if err != nil || !value {
+		t.Errorf("Expected true and no error, but got: %v, %v", value, err)
+	}
+
+	os.Setenv("TEST_BOOL_VAR", "maybe")
+	_, err = LookupBoolEnvVar("TEST_BOOL_VAR", false, logger)
+	if err == nil {
+		t.Error("Expected error for invalid value, but got no error")
+	}
+	os.Unsetenv("TEST_BOOL_VAR")
+
+	value, err = LookupBoolEnvVar("TEST_BOOL_VAR", true, logger)
+	if err != nil || !value {
+		t.Errorf("Expected default value true, but got: %v, %v", value, err)
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	res := SplitList("POS, VISA\n\nEFTPOS ,", ",\n")
+	expected := []string{"POS", "VISA", "EFTPOS"}
+	if len(res) != len(expected) {
+		t.Fatalf("Expected %v, but got: %v", expected, res)
+	}
+	for i := range expected {
+		if res[i] != expected[i] {
+			t.Errorf("Expected %v, but got: %v", expected, res)
+		}
+	}
+}
+
+func TestTrainingExcludedTypes(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	t.Setenv("FF_API_KEY", "test_api_key")
+	t.Setenv("FF_APP_URL", "test_app_url")
+
+	cfg, err := NewConfig(logger)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if !slices.Equal(cfg.TrainingExcludedTypes, DefaultExcludedTypes) {
+		t.Errorf("Expected %v, but got: %v", DefaultExcludedTypes, cfg.TrainingExcludedTypes)
+	}
+
+	t.Setenv("FF_TRAINING_EXCLUDED_TYPES", "Transfer, opening balance")
+	cfg, err = NewConfig(logger)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	expected := []string{"transfer", "opening balance"}
+	if !slices.Equal(cfg.TrainingExcludedTypes, expected) {
+		t.Errorf("Expected %v, but got: %v", expected, cfg.TrainingExcludedTypes)
+	}
+
+	// nothing is excluded
+	t.Setenv("FF_TRAINING_EXCLUDED_TYPES", "")
+	cfg, err = NewConfig(logger)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if len(cfg.TrainingExcludedTypes) != 0 {
+		t.Errorf("Expected no excluded types, but got: %v", cfg.TrainingExcludedTypes)
+	}
+
+	t.Setenv("FF_TRAINING_EXCLUDED_TYPES", "transfers")
+	_, err = NewConfig(logger)
+	if err == nil {
+		t.Error("Expected error due to unknown transaction type, but got no error")
+	}
+}
+
+func TestTrainingOptions(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	t.Setenv("FF_API_KEY", "test_api_key")
+	t.Setenv("FF_APP_URL", "test_app_url")
+	t.Setenv("FF_PRIOR", "smoothed")
+	t.Setenv("FF_MAX_CATEGORY_SAMPLES", "100")
+	t.Setenv("FF_BALANCE_CATEGORIES", "true")
+	t.Setenv("FF_MAX_AGE_DAYS", "730")
+
+	cfg, err := NewConfig(logger)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	// recent transactions weigh more by default
+	expected := classifier.TrainingOptions{Prior: "smoothed", MaxSamples: 100, Balance: true, HalfLifeDays: 365, MaxAgeDays: 730}
+	if cfg.Features.Training != expected {
+		t.Errorf("Expected %+v, but got: %+v", expected, cfg.Features.Training)
+	}
+
+	t.Setenv("FF_PRIOR", "flat")
+	_, err = NewConfig(logger)
+	if err == nil {
+		t.Error("Expected error due to unknown prior, but got no error")
+	}
+}
+
+func TestSuggestMode(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	t.Setenv("FF_API_KEY", "test_api_key")
+	t.Setenv("FF_APP_URL", "test_app_url")
+
+	cfg, err := NewConfig(logger)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if cfg.SuggestMode != SuggestOff || cfg.SuggestTag != DefaultSuggestTag {
+		t.Errorf("Expected mode '%s' and tag '%s', but got: '%s' and '%s'", SuggestOff, DefaultSuggestTag, cfg.SuggestMode, cfg.SuggestTag)
+	}
+
+	// empty tag disables it
+	t.Setenv("FF_SUGGEST_MODE", "low-confidence")
+	t.Setenv("FF_SUGGEST_TAG", "")
+	cfg, err = NewConfig(logger)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if cfg.SuggestMode != SuggestLowConfidence || cfg.SuggestTag != "" {
+		t.Errorf("Expected mode '%s' and no tag, but got: '%s' and '%s'", SuggestLowConfidence, cfg.SuggestMode, cfg.SuggestTag)
+	}
+
+	t.Setenv("FF_SUGGEST_MODE", "notes")
+	_, err = NewConfig(logger)
+	if err == nil {
+		t.Error("Expected error due to unknown suggest mode, but got no error")
+	}
+}
@@ -0,0 +1,270 @@
+package evaluation
+
+import (
+	"ffiiitc/internal/classifier"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-pkgz/lgr"
+)
+
+// evaluation methods
+const (
+	MethodKFold   = "k-fold"
+	MethodHoldout = "holdout"
+)
+
+// evaluation settings
+type Options struct {
+	Backend  string
+	Features classifier.FeatureOptions
+	Method   string
+	Folds    int     // number of folds for stratified k-fold
+	Holdout  float64 // share of latest transactions used for testing in holdout
+	Seed     int64   // seed for shuffling transactions into folds
+
+	Validation classifier.ValidationOptions // applied to data set as before training
+}
+
+// quality metrics of single category
+type CategoryMetrics struct {
+	Category  string  `json:"category"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+	Support   int     `json:"support"` // number of test transactions in category
+}
+
+// evaluation results
+// confusion matrix rows are actual categories and
+// columns are predicted ones, both in order of Labels
+type Report struct {
+	Backend         string                     `json:"backend"`
+	Method          string                     `json:"method"`
+	Folds           int                        `json:"folds,omitempty"`
+	Holdout         float64                    `json:"holdout,omitempty"`
+	Training        classifier.TrainingOptions `json:"training"`
+	DataSet         classifier.DataSetSummary  `json:"data_set"`
+	Transactions    int                        `json:"transactions"`
+	Tested          int                        `json:"tested"`
+	Accuracy        float64                    `json:"accuracy"`
+	Categories      []CategoryMetrics          `json:"categories"`
+	Labels          []string                   `json:"labels"`
+	ConfusionMatrix [][]int                    `json:"confusion_matrix"`
+}
+
+// evaluate classifier on data set
+// data set is validated the same way as for training,
+// so transactions without category are not used
+func Evaluate(dataSet classifier.TransactionDataSet, opts Options, l *lgr.Logger) (Report, error) {
+	data, summary, err := classifier.ValidateDataSet(dataSet, opts.Validation)
+	if err != nil {
+		return Report{}, err
+	}
+	l.Logf("INFO evaluation: data set %s", summary)
+
+	var splits []split
+	switch opts.Method {
+	case MethodKFold:
+		splits, err = kFoldSplits(data, opts.Folds, opts.Seed)
+	case MethodHoldout:
+		splits, err = holdoutSplit(data, opts.Holdout)
+	default:
+		err = fmt.Errorf("unknown evaluation method '%s'", opts.Method)
+	}
+	if err != nil {
+		return Report{}, err
+	}
+
+	labels := categories(data)
+	index := make(map[string]int)
+	for i, label := range labels {
+		index[label] = i
+	}
+	matrix := make([][]int, len(labels))
+	for i := range matrix {
+		matrix[i] = make([]int, len(labels))
+	}
+
+	for i, s := range splits {
+		l.Logf("INFO evaluation: split %d/%d, training on %d, testing on %d transactions", i+1, len(splits), len(s.train), len(s.test))
+		cls, err := classifier.NewClassifierWithTraining(opts.Backend, s.train, opts.Features, l)
+		if err != nil {
+			return Report{}, fmt.Errorf("training on split %d: %w", i+1, err)
+		}
+		for _, trn := range s.test {
+			predicted := cls.Predict(trn).Category
+			matrix[index[trn.Category]][index[predicted]]++
+		}
+	}
+
+	report := buildReport(labels, matrix)
+	report.Backend = opts.Backend
+	report.Method = opts.Method
+	report.Training = opts.Features.Training
+	report.DataSet = summary
+	report.Transactions = len(data)
+	if opts.Method == MethodKFold {
+		report.Folds = opts.Folds
+	} else {
+		report.Holdout = opts.Holdout
+	}
+	return report, nil
+}
+
+// train and test parts of data set
+type split struct {
+	train classifier.TransactionDataSet
+	test  classifier.TransactionDataSet
+}
+
+// split data set into k folds keeping share of every category
+// in each fold, every fold is used as test set once
+func kFoldSplits(data classifier.TransactionDataSet, k int, seed int64) ([]split, error) {
+	if k < 2 || k > len(data) {
+		return nil, fmt.Errorf("number of folds must be between 2 and %d, got %d", len(data), k)
+	}
+
+	// group by category and deal transactions of every category to folds
+	byCategory := make(map[string]classifier.TransactionDataSet)
+	for _, trn := range data {
+		byCategory[trn.Category] = append(byCategory[trn.Category], trn)
+	}
+	rnd := rand.New(rand.NewSource(seed))
+	folds := make([]classifier.TransactionDataSet, k)
+	next := 0
+	for _, cat := range categories(data) {
+		trns := byCategory[cat]
+		rnd.Shuffle(len(trns), func(i, j int) {
+			trns[i], trns[j] = trns[j], trns[i]
+		})
+		for _, trn := range trns {
+			folds[next] = append(folds[next], trn)
+			next = (next + 1) % k
+		}
+	}
+
+	var splits []split
+	for i := range folds {
+		var s split
+		for j, fold := range folds {
+			if i == j {
+				s.test = append(s.test, fold...)
+			} else {
+				s.train = append(s.train, fold...)
+			}
+		}
+		splits = append(splits, s)
+	}
+	return splits, nil
+}
+
+// train on older transactions and test on the latest share of them
+func holdoutSplit(data classifier.TransactionDataSet, share float64) ([]split, error) {
+	if share <= 0 || share >= 1 {
+		return nil, fmt.Errorf("holdout share must be between 0 and 1, got %v", share)
+	}
+	sorted := append(classifier.TransactionDataSet{}, data...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.Before(sorted[j].Date)
+	})
+	testSize := int(float64(len(sorted)) * share)
+	if testSize == 0 || testSize == len(sorted) {
+		return nil, fmt.Errorf("holdout share %v leaves no transactions for training or testing", share)
+	}
+	cut := len(sorted) - testSize
+	return []split{{train: sorted[:cut], test: sorted[cut:]}}, nil
+}
+
+// get sorted unique categories of data set
+func categories(data classifier.TransactionDataSet) []string {
+	seen := make(map[string]bool)
+	var res []string
+	for _, trn := range data {
+		if !seen[trn.Category] {
+			seen[trn.Category] = true
+			res = append(res, trn.Category)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// calculate accuracy and per category metrics from confusion matrix
+func buildReport(labels []string, matrix [][]int) Report {
+	report := Report{
+		Labels:          labels,
+		ConfusionMatrix: matrix,
+		Categories:      []CategoryMetrics{},
+	}
+	correct := 0
+	for i, label := range labels {
+		truePositive := matrix[i][i]
+		actual, predicted := 0, 0
+		for j := range labels {
+			actual += matrix[i][j]
+			predicted += matrix[j][i]
+		}
+		correct += truePositive
+		report.Tested += actual
+
+		m := CategoryMetrics{Category: label, Support: actual}
+		if predicted > 0 {
+			m.Precision = float64(truePositive) / float64(predicted)
+		}
+		if actual > 0 {
+			m.Recall = float64(truePositive) / float64(actual)
+		}
+		if m.Precision+m.Recall > 0 {
+			m.F1 = 2 * m.Precision * m.Recall / (m.Precision + m.Recall)
+		}
+		report.Categories = append(report.Categories, m)
+	}
+	if report.Tested > 0 {
+		report.Accuracy = float64(correct) / float64(report.Tested)
+	}
+	return report
+}
+
+// format report as human readable text
+func (r Report) Text() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "backend: %s\n", r.Backend)
+	switch r.Method {
+	case MethodKFold:
+		fmt.Fprintf(&sb, "method: stratified %d-fold cross-validation\n", r.Folds)
+	case MethodHoldout:
+		fmt.Fprintf(&sb, "method: time based holdout of latest %.0f%%\n", r.Holdout*100)
+	}
+	fmt.Fprintf(&sb, "training: %s\n", r.Training)
+	fmt.Fprintf(&sb, "data set: %s\n", r.DataSet)
+	fmt.Fprintf(&sb, "transactions: %d, tested: %d\n", r.Transactions, r.Tested)
+	fmt.Fprintf(&sb, "accuracy: %.3f\n\n", r.Accuracy)
+
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "category\tprecision\trecall\tf1\tsupport")
+	for _, m := range r.Categories {
+		fmt.Fprintf(tw, "%s\t%.3f\t%.3f\t%.3f\t%d\n", m.Category, m.Precision, m.Recall, m.F1, m.Support)
+	}
+	tw.Flush()
+
+	sb.WriteString("\nconfusion matrix (rows: actual, columns: predicted)\n")
+	tw = tabwriter.NewWriter(&sb, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := []string{""}
+	for i := range r.Labels {
+		header = append(header, fmt.Sprintf("[%d]", i+1))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+	for i, row := range r.ConfusionMatrix {
+		cells := []string{fmt.Sprintf("[%d] %s", i+1, r.Labels[i])}
+		for _, count := range row {
+			cells = append(cells, fmt.Sprint(count))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t")+"\t")
+	}
+	tw.Flush()
+	return sb.String()
+}
@@ -0,0 +1,109 @@
+package evaluation
+
+import (
+	"encoding/json"
+	"ffiiitc/internal/classifier"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/lgr"
+	"github.com/stretchr/testify/assert"
+)
+
+func testDataSet() classifier.TransactionDataSet {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	var data classifier.TransactionDataSet
+	for i, desc := range []string{"WOOLWORTHS METRO", "COLES SUPERMARKET", "ALDI STORES", "WOOLWORTHS ONLINE", "COLES EXPRESS", "ALDI MARKET"} {
+		data = append(data, classifier.Transaction{Category: "Groceries", Description: desc, Date: start.AddDate(0, 0, 2*i)})
+	}
+	for i, desc := range []string{"UBER TRIP", "OPAL TOPUP", "UBER RIDE", "OPAL CARD", "TAXI FARE", "UBER TRIP HELP"} {
+		data = append(data, classifier.Transaction{Category: "Transport", Description: desc, Date: start.AddDate(0, 0, 2*i+1)})
+	}
+	return append(data, classifier.Transaction{Description: "NOT CATEGORISED"})
+}
+
+func TestEvaluateKFold(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	report, err := Evaluate(testDataSet(), Options{
+		Backend: classifier.BackendMultinomial,
+		Method:  MethodKFold,
+		Folds:   3,
+		Seed:    1,
+	}, logger)
+	assert.NoError(t, err)
+
+	// transaction without category is skipped, the rest is tested once
+	assert.Equal(t, 12, report.Transactions)
+	assert.Equal(t, 12, report.Tested)
+	assert.Equal(t, []string{"Groceries", "Transport"}, report.Labels)
+	assert.Len(t, report.Categories, 2)
+	for _, m := range report.Categories {
+		assert.Equal(t, 6, m.Support)
+	}
+	total := 0
+	for _, row := range report.ConfusionMatrix {
+		for _, count := range row {
+			total += count
+		}
+	}
+	assert.Equal(t, 12, total)
+	assert.Positive(t, report.Accuracy)
+
+	// same seed gives same folds
+	again, err := Evaluate(testDataSet(), Options{
+		Backend: classifier.BackendMultinomial,
+		Method:  MethodKFold,
+		Folds:   3,
+		Seed:    1,
+	}, logger)
+	assert.NoError(t, err)
+	assert.Equal(t, report, again)
+
+	_, err = json.Marshal(report)
+	assert.NoError(t, err)
+	assert.Contains(t, report.Text(), "accuracy:")
+}
+
+func TestEvaluateHoldout(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	report, err := Evaluate(testDataSet(), Options{
+		Backend:  classifier.BackendBayesian,
+		Features: classifier.FeatureOptions{Training: classifier.TrainingOptions{Prior: classifier.PriorUniform}},
+		Method:   MethodHoldout,
+		Holdout:  0.25,
+	}, logger)
+	assert.NoError(t, err)
+	assert.Equal(t, 12, report.Transactions)
+	assert.Equal(t, 3, report.Tested)
+
+	// training settings are reported
+	assert.Equal(t, classifier.PriorUniform, report.Training.Prior)
+	assert.Contains(t, report.Text(), "training: prior: uniform")
+}
+
+func TestEvaluateInvalidOptions(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	for _, opts := range []Options{
+		{Backend: classifier.BackendBayesian, Method: MethodKFold, Folds: 1},
+		{Backend: classifier.BackendBayesian, Method: MethodKFold, Folds: 100},
+		{Backend: classifier.BackendBayesian, Method: MethodHoldout, Holdout: 1},
+		{Backend: classifier.BackendBayesian, Method: "magic"},
+	} {
+		_, err := Evaluate(testDataSet(), opts, logger)
+		assert.Error(t, err)
+	}
+}
+
+func TestBuildReport(t *testing.T) {
+	report := buildReport([]string{"A", "B"}, [][]int{
+		{3, 1},
+		{2, 4},
+	})
+	assert.Equal(t, 10, report.Tested)
+	assert.InDelta(t, 0.7, report.Accuracy, 1e-9)
+	assert.InDelta(t, 0.6, report.Categories[0].Precision, 1e-9)
+	assert.InDelta(t, 0.75, report.Categories[0].Recall, 1e-9)
+	assert.InDelta(t, 2*0.6*0.75/1.35, report.Categories[0].F1, 1e-9)
+	assert.InDelta(t, 0.8, report.Categories[1].Precision, 1e-9)
+	assert.InDelta(t, 4.0/6, report.Categories[1].Recall, 1e-9)
+}
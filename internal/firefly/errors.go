@@ -0,0 +1,71 @@
+package firefly
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// error response of firefly api
+// message and field errors are filled from firefly json error body, e.g.
+// {"message": "The given data was invalid.", "errors": {"transactions.0.category_name": ["..."]}}
+type APIError struct {
+	StatusCode int                 `json:"-"`
+	Message    string              `json:"message"`
+	Exception  string              `json:"exception,omitempty"`
+	Errors     map[string][]string `json:"errors,omitempty"` // validation errors by field
+	Body       []byte              `json:"-"`                // raw response body
+}
+
+func newAPIError(statusCode int, body []byte) *APIError {
+	e := &APIError{StatusCode: statusCode, Body: body}
+	// body may be not json at all, e.g. from proxy in front of firefly
+	json.Unmarshal(body, e)
+	return e
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("request failed with status: %d", e.StatusCode)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	fields := make([]string, 0, len(e.Errors))
+	for field := range e.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		msg += fmt.Sprintf(" (%s: %s)", field, strings.Join(e.Errors[field], " "))
+	}
+	return msg
+}
+
+// firefly rejected api key
+func (e *APIError) Unauthorized() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// firefly rejected request data, e.g. unknown category
+func (e *APIError) Invalid() bool {
+	return e.StatusCode == http.StatusUnprocessableEntity
+}
+
+// request may succeed if it is repeated later
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// check if error is caused by rejected api key
+func IsAuthError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Unauthorized()
+}
+
+// check if error is caused by rejected request data
+func IsValidationError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Invalid()
+}
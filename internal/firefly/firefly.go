@@ -2,10 +2,16 @@ package firefly
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"ffiiitc/internal/classifier"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-pkgz/lgr"
@@ -13,8 +19,35 @@ import (
 
 const (
 	fireflyAPIPrefix = "api/v1"
+	ClassifiedTag    = "ffiiitc" // tag added to transactions classified by ffiiitc
+
+	DefaultMaxRetries    = 3
+	DefaultRetryDelay    = time.Second
+	DefaultMaxRetryDelay = 30 * time.Second
+)
+
+// firefly transaction types
+const (
+	TypeWithdrawal      = "withdrawal"
+	TypeDeposit         = "deposit"
+	TypeTransfer        = "transfer"
+	TypeOpeningBalance  = "opening balance"
+	TypeReconciliation  = "reconciliation"
+	TypeLiabilityCredit = "liability credit"
 )
 
+var TransactionTypes = []string{TypeWithdrawal, TypeDeposit, TypeTransfer, TypeOpeningBalance, TypeReconciliation, TypeLiabilityCredit}
+
+// check if transaction type is one of types, case is ignored
+func HasType(trnType string, types []string) bool {
+	for _, t := range types {
+		if strings.EqualFold(trnType, t) {
+			return true
+		}
+	}
+	return false
+}
+
 type Timeout time.Duration
 
 // struct for firefly http client
@@ -24,14 +57,55 @@ type FireFlyHttpClient struct {
 	Timeout Timeout
 	Token   string
 	logger  *lgr.Logger
+
+	// failed requests (network errors, 429 and 5xx) are retried
+	// with exponential backoff and jitter, or after Retry-After
+	MaxRetries    int           // 0 disables retries
+	RetryDelay    time.Duration // delay before first retry, doubled for every next one
+	MaxRetryDelay time.Duration // longer Retry-After is not waited for
+
+	client *http.Client    // shared to reuse connections
+	ctx    context.Context // cancels requests and waits before retries, nil if never cancelled
+
+	ExcludedTypes []string // transaction types left out of training data set
 }
 
 // set of structs for firefly transaction json data
 type FireFlyTransaction struct {
-	Description   string   `json:"description"`
-	Category      string   `json:"category_name"`
-	TransactionID string   `json:"transaction_journal_id"`
-	Tags          []string `json:"tags"`
+	Description     string      `json:"description"`
+	Category        string      `json:"category_name,omitempty"`
+	Budget          string      `json:"budget_name,omitempty"`
+	TransactionID   string      `json:"transaction_journal_id"`
+	Tags            []string    `json:"tags"`
+	Amount          json.Number `json:"amount,omitempty"`
+	Type            string      `json:"type,omitempty"`
+	SourceName      string      `json:"source_name,omitempty"`
+	SourceIBAN      string      `json:"source_iban,omitempty"`
+	DestinationName string      `json:"destination_name,omitempty"`
+	DestinationIBAN string      `json:"destination_iban,omitempty"`
+	CurrencyCode    string      `json:"currency_code,omitempty"`
+	Date            string      `json:"date,omitempty"`
+	Notes           string      `json:"notes,omitempty"`
+}
+
+// convert firefly transaction to classifier transaction
+func (t FireFlyTransaction) ToTransaction() classifier.Transaction {
+	amount, _ := t.Amount.Float64()
+	date, _ := time.Parse(time.RFC3339, t.Date)
+	return classifier.Transaction{
+		Category:        t.Category,
+		Description:     t.Description,
+		Amount:          amount,
+		Type:            t.Type,
+		SourceName:      t.SourceName,
+		SourceIBAN:      t.SourceIBAN,
+		DestinationName: t.DestinationName,
+		DestinationIBAN: t.DestinationIBAN,
+		Currency:        t.CurrencyCode,
+		Date:            date,
+		Tags:            t.Tags,
+		Budget:          t.Budget,
+	}
 }
 
 type FireFlyTransactions struct {
@@ -41,6 +115,7 @@ type FireFlyTransactions struct {
 }
 
 type FireFlyTransactionAttributes struct {
+	Id         string              `json:"id"` // transaction group id
 	Attributes FireFlyTransactions `json:"attributes"`
 }
 
@@ -65,23 +140,70 @@ type FireFlyTransactionsResponse struct {
 
 func NewFireFlyHttpClient(url, token string, timeout Timeout, l *lgr.Logger) *FireFlyHttpClient {
 	return &FireFlyHttpClient{
-		AppURL:  url,
-		Token:   token,
-		Timeout: timeout,
-		logger:  l,
+		AppURL:        url,
+		Token:         token,
+		Timeout:       timeout,
+		logger:        l,
+		MaxRetries:    DefaultMaxRetries,
+		RetryDelay:    DefaultRetryDelay,
+		MaxRetryDelay: DefaultMaxRetryDelay,
+		client: &http.Client{
+			Timeout: time.Duration(timeout) * time.Second, // Set a reasonable timeout for the request.
+		},
 	}
 }
 
+// copy of client, requests of which are cancelled with context
+func (fc *FireFlyHttpClient) WithContext(ctx context.Context) *FireFlyHttpClient {
+	c := *fc
+	c.ctx = ctx
+	return &c
+}
+
+func (fc *FireFlyHttpClient) context() context.Context {
+	if fc.ctx == nil {
+		return context.Background()
+	}
+	return fc.ctx
+}
+
 // helper function to make http request to firefly api
-// returns body
+// retries request if it may succeed later
+// returns body or *APIError if firefly responded with error
 func (fc *FireFlyHttpClient) sendRequestWithToken(method, url, token string, data []byte) ([]byte, error) {
-	client := http.Client{
-		Timeout: time.Duration(fc.Timeout) * time.Second, // Set a reasonable timeout for the request.
+	ctx := fc.context()
+	for attempt := 0; ; attempt++ {
+		body, retry, retryAfter, err := fc.doRequest(ctx, method, url, token, data)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err == nil || !retry || attempt >= fc.MaxRetries {
+			return body, err
+		}
+		delay := backoff(fc.RetryDelay, fc.MaxRetryDelay, attempt)
+		if retryAfter > 0 {
+			if retryAfter > fc.MaxRetryDelay {
+				fc.logger.Logf("WARN %s %s: not retrying, firefly asked to wait %v", method, url, retryAfter)
+				return body, err
+			}
+			delay = retryAfter
+		}
+		fc.logger.Logf("WARN %s %s: %v, retry %d of %d in %v", method, url, err, attempt+1, fc.MaxRetries, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 	}
+}
 
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(data))
+// send request once
+// retry tells if request may succeed later, retryAfter is
+// delay asked by firefly or 0
+func (fc *FireFlyHttpClient) doRequest(ctx context.Context, method, url, token string, data []byte) (body []byte, retry bool, retryAfter time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
 	if err != nil {
-		return nil, err
+		return nil, false, 0, err
 	}
 
 	// Set the Authorization header with the Bearer token.
@@ -89,22 +211,51 @@ func (fc *FireFlyHttpClient) sendRequestWithToken(method, url, token string, dat
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("accept", "application/vnd.api+json")
 
-	resp, err := client.Do(req)
+	resp, err := fc.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, true, 0, err
 	}
 	defer resp.Body.Close()
 
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, 0, err
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("request failed with status: %d", resp.StatusCode)
+		apiErr := newAPIError(resp.StatusCode, bodyBytes)
+		return nil, apiErr.Temporary(), parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()), apiErr
 	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	return bodyBytes, false, 0, nil
+}
+
+// exponential delay before retry with random jitter, so that
+// concurrent requests are not repeated at the same time
+func backoff(base, maxDelay time.Duration, attempt int) time.Duration {
+	delay := base << attempt
+	if delay > maxDelay || delay <= 0 {
+		delay = maxDelay
+	}
+	if delay < 2 {
+		return delay
 	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+}
 
-	return bodyBytes, nil
+// parse Retry-After header, either seconds or http date
+// returns 0 if header is missing or invalid
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
 }
 
 // SendGetRequestWithToken sends an HTTP GET request to the FireFly API with a token.
@@ -117,19 +268,42 @@ func (fc *FireFlyHttpClient) SendPutRequestWithToken(url, token string, data []b
 	return fc.sendRequestWithToken(http.MethodPut, url, token, data)
 }
 
-func (fc *FireFlyHttpClient) UpdateTransactionCategory(id, trans_id, category string, tags []string) error {
+// fields of transaction split to update
+// empty category, budget and notes are left as is,
+// tags replace existing ones
+type TransactionUpdate struct {
+	TransactionID string // journal id of split
+	Description   string
+	Category      string
+	Budget        string
+	Notes         string // replaces existing notes
+	Tags          []string
+}
+
+// update splits of transaction group with single request
+// all splits of the group have to be passed in their order, so that
+// firefly keeps every split, fields not sent are left as is
+// split is tagged as classified by ffiiitc if its category is set
+func (fc *FireFlyHttpClient) UpdateTransactionGroup(id string, updates []TransactionUpdate) error {
 	//log.Printf("updating transaction: %s", id)
 
 	trn := FireFlyTransactions{
 		FireWebHooks: false,
 		Id:           id,
-		Transactions: []FireFlyTransaction{
-			{
-				TransactionID: trans_id,
-				Category:      category,
-				Tags:          append(tags, []string{"ffiiitc"}...),
-			},
-		},
+	}
+	for _, update := range updates {
+		tags := update.Tags
+		if update.Category != "" {
+			tags = append(append([]string{}, tags...), ClassifiedTag)
+		}
+		trn.Transactions = append(trn.Transactions, FireFlyTransaction{
+			TransactionID: update.TransactionID,
+			Description:   update.Description,
+			Category:      update.Category,
+			Budget:        update.Budget,
+			Notes:         update.Notes,
+			Tags:          tags,
+		})
 	}
 
 	//log.Printf("trn data: %v", trn)
@@ -176,16 +350,21 @@ func buildCategoryDescriptionSlice(data FireFlyTransactionsResponse) []string {
 	return res
 }
 
-func buildTransactionsDataset(data FireFlyTransactionsResponse) [][]string {
-	var res [][]string
+// build training data set from transactions page
+// transactions of excluded types are left out and counted
+func buildTransactionsDataset(data FireFlyTransactionsResponse, excludedTypes []string) (classifier.TransactionDataSet, int) {
+	var res classifier.TransactionDataSet
+	excluded := 0
 	for _, value := range data.Data {
 		for _, trnval := range value.Attributes.Transactions {
-			//trn := fmt.Sprintf("%s,%s", trnval.Category, trnval.Description)
-			trn := []string{trnval.Category, trnval.Description}
-			res = append(res, trn)
+			if HasType(trnval.Type, excludedTypes) {
+				excluded++
+				continue
+			}
+			res = append(res, trnval.ToTransaction())
 		}
 	}
-	return res
+	return res, excluded
 }
 
 // get all transactions
@@ -238,68 +417,121 @@ func (fc *FireFlyHttpClient) GetTransactions() ([]string, error) {
 	return resSlice, err
 }
 
-func (fc *FireFlyHttpClient) GetTransactionsDataset(startStr, endStr string) ([][]string, error) {
-	var pageIndex int
-	fc.logger.Logf("INFO get first page of transactions")
+// get transactions data set for training
+// returns slice of transactions with category, description, amount...
+func (fc *FireFlyHttpClient) GetTransactionsDataset(startStr, endStr string) (classifier.TransactionDataSet, error) {
+	return fc.GetTransactionsDatasetWithProgress(startStr, endStr, nil)
+}
+
+// same as GetTransactionsDataset, but calls progress (if not nil)
+// after every page of transactions is fetched
+func (fc *FireFlyHttpClient) GetTransactionsDatasetWithProgress(startStr, endStr string, progress func(page, totalPages int)) (classifier.TransactionDataSet, error) {
+	var resSlice classifier.TransactionDataSet
+	excluded := 0
+	err := fc.forEachTransactionsPage(TransactionFilter{Start: startStr, End: endStr}, 1, func(page int, data FireFlyTransactionsResponse) error {
+		dataSet, pageExcluded := buildTransactionsDataset(data, fc.ExcludedTypes)
+		resSlice = append(resSlice, dataSet...)
+		excluded += pageExcluded
+		if progress != nil {
+			progress(page, data.Meta.Pagination.TotalPages)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if excluded > 0 {
+		fc.logger.Logf("INFO excluded %d transactions of types %v from data set", excluded, fc.ExcludedTypes)
+	}
+	return resSlice, nil
+}
+
+// filter of transactions fetched from firefly
+// empty fields are not applied
+type TransactionFilter struct {
+	Start     string `json:"start,omitempty"` // yyyy-mm-dd
+	End       string `json:"end,omitempty"`   // yyyy-mm-dd
+	AccountID string `json:"account,omitempty"`
+}
+
+// transaction group with all its splits
+type TransactionGroup struct {
+	Id     string
+	Splits []FireFlyTransaction
+}
+
+// fetch transaction groups page by page starting from first page
+// fn is called for every page, iteration stops on its error
+func (fc *FireFlyHttpClient) ForEachTransactionGroupPage(filter TransactionFilter, firstPage int, fn func(page, totalPages int, groups []TransactionGroup) error) error {
+	return fc.forEachTransactionsPage(filter, firstPage, func(page int, data FireFlyTransactionsResponse) error {
+		var groups []TransactionGroup
+		for _, value := range data.Data {
+			groups = append(groups, TransactionGroup{
+				Id:     value.Id,
+				Splits: value.Attributes.Transactions,
+			})
+		}
+		return fn(page, data.Meta.Pagination.TotalPages, groups)
+	})
+}
+
+// fetch transactions page by page starting from first page
+// fn is called for every page, iteration stops on its error
+func (fc *FireFlyHttpClient) forEachTransactionsPage(filter TransactionFilter, firstPage int, fn func(page int, data FireFlyTransactionsResponse) error) error {
 	dateRangeQuery := ""
-	if startStr != "" {
-		_, err := time.Parse("2006-01-02", startStr)
+	if filter.Start != "" {
+		_, err := time.Parse("2006-01-02", filter.Start)
 		if err == nil {
-			dateRangeQuery = fmt.Sprintf("&start=%s", startStr)
-			fc.logger.Logf("DEBUG start date: %s", startStr)
+			dateRangeQuery = fmt.Sprintf("&start=%s", filter.Start)
+			fc.logger.Logf("DEBUG start date: %s", filter.Start)
 		} else {
 			fc.logger.Logf("WARN invalid start date format: %v", err)
 		}
 	}
 
-	if endStr != "" {
-		_, err := time.Parse("2006-01-02", endStr)
+	if filter.End != "" {
+		_, err := time.Parse("2006-01-02", filter.End)
 		if err == nil {
-			dateRangeQuery += fmt.Sprintf("&end=%s", endStr)
-			fc.logger.Logf("DEBUG end date: %s", endStr)
+			dateRangeQuery += fmt.Sprintf("&end=%s", filter.End)
+			fc.logger.Logf("DEBUG end date: %s", filter.End)
 		} else {
 			fc.logger.Logf("WARN invalid end date format: %v", err)
 		}
 	}
 
-	res, err := fc.SendGetRequestWithToken(
-		fmt.Sprintf("%s/%s/transactions?page=1%s", fc.AppURL, fireflyAPIPrefix, dateRangeQuery),
-		fc.Token,
-	)
-	if err != nil {
-		return nil, err
+	endpoint := "transactions"
+	if filter.AccountID != "" {
+		endpoint = fmt.Sprintf("accounts/%s/transactions", url.PathEscape(filter.AccountID))
 	}
 
-	var data FireFlyTransactionsResponse
-	err = json.Unmarshal(res, &data)
-	if err != nil {
-		return nil, err
+	if firstPage < 1 {
+		firstPage = 1
 	}
-	fc.logger.Logf("DEBUG raw transactions data: %v", data)
-
-	resSlice := buildTransactionsDataset(data)
-
-	fc.logger.Logf("INFO transactions total pages: %d", data.Meta.Pagination.TotalPages)
-	if data.Meta.Pagination.TotalPages > 1 {
-		fc.logger.Logf("INFO transactions more than 1 page available. iterating")
-		pageIndex = 2
-		for pageIndex <= data.Meta.Pagination.TotalPages {
-			res, err := fc.SendGetRequestWithToken(
-				fmt.Sprintf("%s/%s/transactions?page=%d%s", fc.AppURL, fireflyAPIPrefix, pageIndex, dateRangeQuery),
-				fc.Token,
-			)
-			if err != nil {
-				return nil, err
-			}
-			var data FireFlyTransactionsResponse
-			err = json.Unmarshal(res, &data)
-			if err != nil {
-				return nil, err
-			}
-			resSlice = append(resSlice, buildTransactionsDataset(data)...)
+	fc.logger.Logf("INFO get page %d of transactions", firstPage)
+	for pageIndex, totalPages := firstPage, firstPage; pageIndex <= totalPages; pageIndex++ {
+		res, err := fc.SendGetRequestWithToken(
+			fmt.Sprintf("%s/%s/%s?page=%d%s", fc.AppURL, fireflyAPIPrefix, endpoint, pageIndex, dateRangeQuery),
+			fc.Token,
+		)
+		if err != nil {
+			return err
+		}
+		var data FireFlyTransactionsResponse
+		err = json.Unmarshal(res, &data)
+		if err != nil {
+			return err
+		}
+		totalPages = data.Meta.Pagination.TotalPages
+		if pageIndex == firstPage {
+			fc.logger.Logf("DEBUG raw transactions data: %v", data)
+			fc.logger.Logf("INFO transactions total pages: %d", totalPages)
+		} else {
 			fc.logger.Logf("INFO page %d...", pageIndex)
-			pageIndex++
+		}
+		err = fn(pageIndex, data)
+		if err != nil {
+			return err
 		}
 	}
-	return resSlice, err
+	return nil
 }
@@ -0,0 +1,151 @@
+package firefly
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/lgr"
+	"github.com/stretchr/testify/assert"
+)
+
+// fake firefly server responding with given statuses in turn, the last one is repeated
+func newStatusServer(t *testing.T, header http.Header, body string, statuses ...int) (*FireFlyHttpClient, *int) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[len(statuses)-1]
+		if requests < len(statuses) {
+			status = statuses[requests]
+		}
+		requests++
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	fc := NewFireFlyHttpClient(srv.URL, "token", 10, lgr.New(lgr.Debug, lgr.CallerFunc))
+	fc.RetryDelay = time.Millisecond
+	return fc, &requests
+}
+
+func TestSendRequestRetries(t *testing.T) {
+	fc, requests := newStatusServer(t, nil, "", http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	res, err := fc.SendGetRequestWithToken(fc.AppURL+"/api/v1/transactions", fc.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, `{}`, string(res))
+	assert.Equal(t, 3, *requests)
+
+	// gives up after max retries
+	fc, requests = newStatusServer(t, nil, `{"message": "Server Error"}`, http.StatusBadGateway)
+	_, err = fc.SendGetRequestWithToken(fc.AppURL+"/api/v1/transactions", fc.Token)
+	assert.EqualError(t, err, "request failed with status: 502: Server Error")
+	assert.Equal(t, DefaultMaxRetries+1, *requests)
+
+	// longer wait than allowed is not waited for
+	fc, requests = newStatusServer(t, http.Header{"Retry-After": {"120"}}, "", http.StatusTooManyRequests)
+	_, err = fc.SendGetRequestWithToken(fc.AppURL+"/api/v1/transactions", fc.Token)
+	assert.Error(t, err)
+	assert.Equal(t, 1, *requests)
+}
+
+func TestSendRequestCancelled(t *testing.T) {
+	fc, requests := newStatusServer(t, nil, "", http.StatusServiceUnavailable)
+	fc.RetryDelay, fc.MaxRetryDelay = time.Hour, time.Hour
+
+	// waiting before retry is cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := fc.WithContext(ctx).SendGetRequestWithToken(fc.AppURL, fc.Token)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, 1, *requests)
+
+	// cancelled client does not send requests
+	_, err = fc.WithContext(ctx).SendGetRequestWithToken(fc.AppURL, fc.Token)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, *requests)
+}
+
+func TestSendRequestErrors(t *testing.T) {
+	body := `{"message": "The given data was invalid.", "errors": {"transactions.0.category_name": ["Category is invalid."]}}`
+	fc, requests := newStatusServer(t, nil, body, http.StatusUnprocessableEntity)
+	err := fc.UpdateTransactionGroup("1", []TransactionUpdate{{TransactionID: "2", Category: "Unknown"}})
+	assert.Equal(t, 1, *requests)
+	assert.True(t, IsValidationError(err))
+	assert.False(t, IsAuthError(err))
+	assert.EqualError(t, err, "request failed with status: 422: The given data was invalid. (transactions.0.category_name: Category is invalid.)")
+
+	var apiErr *APIError
+	if assert.ErrorAs(t, err, &apiErr) {
+		assert.Equal(t, http.StatusUnprocessableEntity, apiErr.StatusCode)
+		assert.Equal(t, []string{"Category is invalid."}, apiErr.Errors["transactions.0.category_name"])
+		assert.Equal(t, body, string(apiErr.Body))
+	}
+
+	fc, requests = newStatusServer(t, nil, `{"message": "Unauthenticated.", "exception": "AuthenticationException"}`, http.StatusUnauthorized)
+	_, err = fc.GetTransactionsDataset("", "")
+	assert.Equal(t, 1, *requests)
+	assert.True(t, IsAuthError(err))
+	assert.False(t, IsValidationError(err))
+
+	// not json body of error is kept as is
+	fc, _ = newStatusServer(t, nil, "<html>Forbidden</html>", http.StatusForbidden)
+	_, err = fc.SendGetRequestWithToken(fc.AppURL, fc.Token)
+	assert.True(t, IsAuthError(err))
+	assert.EqualError(t, err, "request failed with status: 403")
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		delay := backoff(time.Second, 30*time.Second, attempt)
+		expected := time.Second << attempt
+		if expected > 30*time.Second {
+			expected = 30 * time.Second
+		}
+		assert.GreaterOrEqual(t, delay, expected/2)
+		assert.Less(t, delay, expected)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, 5*time.Second, parseRetryAfter("5", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter("Tue, 02 Jan 2024 10:01:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Tue, 02 Jan 2024 09:00:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+}
+
+func TestGetTransactionsDatasetExcludedTypes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": [{"id": "1", "attributes": {"transactions": [
+			{"description": "WOOLWORTHS", "category_name": "Groceries", "type": "withdrawal"},
+			{"description": "SAVINGS", "category_name": "Savings", "type": "transfer"},
+			{"description": "Initial balance", "type": "Opening balance"},
+			{"description": "SALARY", "category_name": "Income", "type": "deposit"}
+		]}}], "meta": {"pagination": {"total_pages": 1}}}`))
+	}))
+	defer srv.Close()
+	fc := NewFireFlyHttpClient(srv.URL, "token", 10, lgr.New(lgr.Debug, lgr.CallerFunc))
+
+	dataSet, err := fc.GetTransactionsDataset("", "")
+	assert.NoError(t, err)
+	assert.Len(t, dataSet, 4)
+
+	fc.ExcludedTypes = []string{TypeTransfer, TypeOpeningBalance, TypeReconciliation}
+	dataSet, err = fc.GetTransactionsDataset("", "")
+	assert.NoError(t, err)
+	if assert.Len(t, dataSet, 2) {
+		assert.Equal(t, "WOOLWORTHS", dataSet[0].Description)
+		assert.Equal(t, "SALARY", dataSet[1].Description)
+	}
+}
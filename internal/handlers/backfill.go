@@ -0,0 +1,451 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"ffiiitc/internal/config"
+	"ffiiitc/internal/firefly"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backfill states
+const (
+	BackfillRunning  = "running"
+	BackfillStopped  = "stopped" // interrupted, continues on next run with the same filters
+	BackfillFailed   = "failed"  // continues on next run with the same filters
+	BackfillFinished = "finished"
+
+	DefaultBackfillRate = 2.0 // transaction updates per second
+)
+
+var ErrBackfillRunning = errors.New("backfill is already running")
+
+// options of bulk classification of existing transactions
+type BackfillOptions struct {
+	firefly.TransactionFilter
+	Uncategorised bool    `json:"uncategorised"` // only classify transactions without category
+	DryRun        bool    `json:"dry_run"`       // only report what would be updated
+	Rate          float64 `json:"rate"`          // max transaction updates per second, 0 for no limit
+}
+
+// backfill progress
+// it is saved after every page of transactions, so that
+// stopped or failed backfill continues where it was left
+type BackfillProgress struct {
+	Options      BackfillOptions `json:"options"`
+	State        string          `json:"state"`
+	Page         int             `json:"page"` // last processed page
+	TotalPages   int             `json:"total_pages"`
+	Transactions int             `json:"transactions"` // processed splits
+	Updated      int             `json:"updated"`
+	Skipped      int             `json:"skipped"`
+	Failed       int             `json:"failed"`
+	Error        string          `json:"error,omitempty"`
+	StartedAt    time.Time       `json:"started_at"`
+	FinishedAt   *time.Time      `json:"finished_at,omitempty"`
+}
+
+// check if saved progress can be continued with options
+func (p BackfillProgress) resumable(opts BackfillOptions) bool {
+	return p.State != BackfillFinished && !p.Options.DryRun && !opts.DryRun &&
+		p.Options.TransactionFilter == opts.TransactionFilter &&
+		p.Options.Uncategorised == opts.Uncategorised
+}
+
+// status of backfill in progress or the last one
+type backfillStatus struct {
+	mu       sync.Mutex
+	running  bool
+	cancel   context.CancelFunc // stops running backfill
+	done     chan struct{}      // closed once running backfill ends
+	progress *BackfillProgress
+}
+
+// mark backfill as running, false if it is running already
+// backfill runs with returned context, so that it can be stopped
+func (s *backfillStatus) begin(ctx context.Context, opts BackfillOptions) (context.Context, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return ctx, false
+	}
+	s.running = true
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+	s.progress = &BackfillProgress{Options: opts, State: BackfillRunning, StartedAt: time.Now()}
+	return ctx, true
+}
+
+func (s *backfillStatus) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = false
+	s.cancel()
+	close(s.done)
+}
+
+// stop running backfill, returns channel closed once it ends
+// false if there is no backfill running
+func (s *backfillStatus) stop() (<-chan struct{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running {
+		return nil, false
+	}
+	s.cancel()
+	return s.done, true
+}
+
+func (s *backfillStatus) set(p BackfillProgress) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.progress = &p
+}
+
+func (s *backfillStatus) get() (BackfillProgress, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.progress == nil {
+		return BackfillProgress{}, false
+	}
+	return *s.progress, true
+}
+
+// columns of backfill csv report
+var backfillReportHeader = []string{
+	"group_id", "transaction_journal_id", "date", "description", "amount",
+	"current_category", "predicted_category", "confidence", "rule",
+	"category", "budget", "tags", "action", "reason", "suggestions",
+}
+
+// classify existing transactions and update them in firefly
+// report (if not nil) gets csv row for every classified split
+func (wh *WebHookHandler) Backfill(ctx context.Context, opts BackfillOptions, report io.Writer) (BackfillProgress, error) {
+	ctx, ok := wh.backfill.begin(ctx, opts)
+	if !ok {
+		progress, _ := wh.backfill.get()
+		return progress, ErrBackfillRunning
+	}
+	defer wh.backfill.end()
+	return wh.runBackfill(ctx, opts, report)
+}
+
+// stop running backfill and wait until its progress is saved
+// false if there is no backfill running
+func (wh *WebHookHandler) StopBackfill() (BackfillProgress, bool) {
+	done, ok := wh.backfill.stop()
+	if !ok {
+		return BackfillProgress{}, false
+	}
+	<-done
+	progress, _ := wh.backfill.get()
+	return progress, true
+}
+
+func (wh *WebHookHandler) runBackfill(ctx context.Context, opts BackfillOptions, report io.Writer) (BackfillProgress, error) {
+	progress, _ := wh.backfill.get()
+	if saved, err := loadBackfillProgress(wh.BackfillStateFile); err == nil && saved.resumable(opts) {
+		wh.Logger.Logf("INFO backfill: resuming after page %d of %d", saved.Page, saved.TotalPages)
+		progress = saved
+		progress.Options.Rate = opts.Rate
+		progress.State = BackfillRunning
+		progress.Error = ""
+		progress.FinishedAt = nil
+	}
+	wh.backfill.set(progress)
+
+	var csvWriter *csv.Writer
+	if report != nil {
+		csvWriter = csv.NewWriter(report)
+		csvWriter.Write(backfillReportHeader)
+	}
+
+	// transactions already categorised by hand
+	// are never overwritten when only uncategorised are requested
+	groupOpts := groupOptions{
+		policy:        wh.Config.OverwritePolicy,
+		uncategorised: opts.Uncategorised,
+		dryRun:        opts.DryRun,
+		limiter:       newRateLimiter(opts.Rate),
+	}
+	if opts.Uncategorised {
+		groupOpts.policy = config.OverwriteOnlyEmpty
+	}
+
+	cls := wh.Classifier()
+	err := wh.FireflyClient.WithContext(ctx).ForEachTransactionGroupPage(opts.TransactionFilter, progress.Page+1, func(page, totalPages int, groups []firefly.TransactionGroup) error {
+		for _, group := range groups {
+			var splits []FireflyTrn
+			for _, split := range group.Splits {
+				splits = append(splits, webhookTransaction(split))
+			}
+			if opts.Uncategorised && !hasUncategorised(splits) {
+				continue
+			}
+			// rejected update of single group is reported, but
+			// backfill cannot go on without valid api key
+			// or once it is stopped
+			results, err := wh.classifyGroup(ctx, cls, group.Id, splits, groupOpts)
+			if err != nil && (firefly.IsAuthError(err) || errors.Is(err, ctx.Err())) {
+				return err
+			}
+			for i, res := range results {
+				action := "skipped"
+				switch {
+				case res.Error != "":
+					action = "failed"
+					progress.Failed++
+				case res.Updated && opts.DryRun:
+					action = "would update"
+					progress.Updated++
+				case res.Updated:
+					action = "updated"
+					progress.Updated++
+				default:
+					progress.Skipped++
+				}
+				progress.Transactions++
+				if csvWriter != nil {
+					csvWriter.Write(backfillReportRow(group.Id, splits[i], res, action))
+				}
+			}
+		}
+
+		progress.Page, progress.TotalPages = page, totalPages
+		wh.backfill.set(progress)
+		wh.Logger.Logf("INFO backfill: page %d of %d done, %d transactions processed", page, totalPages, progress.Transactions)
+		if !opts.DryRun {
+			err := saveBackfillProgress(wh.BackfillStateFile, progress)
+			if err != nil {
+				return fmt.Errorf("saving backfill progress: %w", err)
+			}
+		}
+		if csvWriter != nil {
+			csvWriter.Flush()
+			err := csvWriter.Error()
+			if err != nil {
+				return err
+			}
+		}
+		return ctx.Err()
+	})
+
+	now := time.Now()
+	progress.FinishedAt = &now
+	switch {
+	case err == nil:
+		progress.State = BackfillFinished
+	case errors.Is(err, context.Canceled):
+		progress.State = BackfillStopped
+	default:
+		progress.State = BackfillFailed
+		progress.Error = err.Error()
+	}
+	wh.backfill.set(progress)
+	wh.Logger.Logf("INFO backfill: %s, %d transactions processed, %d updated, %d skipped, %d failed",
+		progress.State, progress.Transactions, progress.Updated, progress.Skipped, progress.Failed)
+	if !opts.DryRun {
+		saveErr := saveBackfillProgress(wh.BackfillStateFile, progress)
+		if saveErr != nil {
+			wh.Logger.Logf("ERROR backfill: saving progress: %v", saveErr)
+		}
+	}
+	return progress, err
+}
+
+// http handler for bulk classification of existing transactions
+// POST starts backfill with filters from query, dry run responds with
+// csv report straight away, real one runs in background
+// GET returns progress of running or the last backfill
+// DELETE stops running backfill, it continues on next run with the same filters
+func (wh *WebHookHandler) HandleBackfill(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodDelete:
+		progress, ok := wh.StopBackfill()
+		if !ok {
+			http.Error(w, "no backfill running", http.StatusNotFound)
+			return
+		}
+		wh.Logger.Logf("INFO backfill: stopped on request")
+		writeJSON(w, http.StatusOK, progress)
+		return
+	case http.MethodGet:
+		progress, exist := wh.backfill.get()
+		if !exist {
+			var err error
+			progress, err = loadBackfillProgress(wh.BackfillStateFile)
+			if err != nil {
+				http.Error(w, "no backfill found", http.StatusNotFound)
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, progress)
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	opts, err := parseBackfillOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// backfill in background outlives request,
+	// it is stopped with DELETE or on shutdown
+	ctx := r.Context()
+	if !opts.DryRun {
+		ctx = context.Background()
+	}
+	ctx, ok := wh.backfill.begin(ctx, opts)
+	if !ok {
+		progress, _ := wh.backfill.get()
+		writeJSON(w, http.StatusConflict, progress)
+		return
+	}
+
+	wh.Logger.Logf("INFO backfill: started %+v", opts)
+	if opts.DryRun {
+		defer wh.backfill.end()
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="backfill.csv"`)
+		wh.runBackfill(ctx, opts, w)
+		return
+	}
+	go func() {
+		defer wh.backfill.end()
+		wh.runBackfill(ctx, opts, nil)
+	}()
+	progress, _ := wh.backfill.get()
+	writeJSON(w, http.StatusAccepted, progress)
+}
+
+// get backfill options from request query
+func parseBackfillOptions(r *http.Request) (BackfillOptions, error) {
+	opts := BackfillOptions{
+		TransactionFilter: firefly.TransactionFilter{
+			Start:     r.FormValue("start"),
+			End:       r.FormValue("end"),
+			AccountID: r.FormValue("account"),
+		},
+		Rate: DefaultBackfillRate,
+	}
+	var err error
+	for name, value := range map[string]*bool{
+		"uncategorised": &opts.Uncategorised,
+		"dry_run":       &opts.DryRun,
+	} {
+		if v := r.FormValue(name); v != "" {
+			*value, err = strconv.ParseBool(v)
+			if err != nil {
+				return opts, fmt.Errorf("%s must be true or false", name)
+			}
+		}
+	}
+	if v := r.FormValue("rate"); v != "" {
+		opts.Rate, err = strconv.ParseFloat(v, 64)
+		if err != nil || opts.Rate < 0 {
+			return opts, errors.New("rate must be non-negative number")
+		}
+	}
+	return opts, nil
+}
+
+// convert transaction split from firefly api to webhook one
+func webhookTransaction(t firefly.FireFlyTransaction) FireflyTrn {
+	return FireflyTrn{
+		Id:              t.TransactionID,
+		Description:     t.Description,
+		Category:        t.Category,
+		Budget:          t.Budget,
+		Tags:            t.Tags,
+		Amount:          t.Amount,
+		Type:            t.Type,
+		SourceName:      t.SourceName,
+		SourceIBAN:      t.SourceIBAN,
+		DestinationName: t.DestinationName,
+		DestinationIBAN: t.DestinationIBAN,
+		CurrencyCode:    t.CurrencyCode,
+		Date:            t.Date,
+		Notes:           t.Notes,
+	}
+}
+
+func hasUncategorised(splits []FireflyTrn) bool {
+	for _, split := range splits {
+		if split.Category == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func backfillReportRow(groupId string, trn FireflyTrn, res SplitResult, action string) []string {
+	reason := res.Error
+	if reason == "" {
+		reason = strings.Join(res.Skipped, "; ")
+	}
+	return []string{
+		groupId, trn.Id, trn.Date, trn.Description, trn.Amount.String(),
+		trn.Category, res.Predicted, strconv.FormatFloat(res.Confidence, 'f', 4, 64), res.Rule,
+		res.Category, res.Budget, strings.Join(res.Tags, ","), action, reason,
+		formatSuggestions(res.Suggestions),
+	}
+}
+
+func loadBackfillProgress(name string) (BackfillProgress, error) {
+	var progress BackfillProgress
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return progress, err
+	}
+	err = json.Unmarshal(data, &progress)
+	return progress, err
+}
+
+func saveBackfillProgress(name string, progress BackfillProgress) error {
+	data, err := json.MarshalIndent(progress, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0644)
+}
+
+// spaces out firefly updates to at most rate per second
+type rateLimiter struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// wait for next update slot
+func (rl *rateLimiter) wait(ctx context.Context) error {
+	if rl.interval == 0 {
+		return ctx.Err()
+	}
+	if d := time.Until(rl.next); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	rl.next = time.Now().Add(rl.interval)
+	return nil
+}
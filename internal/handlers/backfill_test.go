@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"ffiiitc/internal/config"
+	"ffiiitc/internal/firefly"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// two pages of existing transaction groups, second group is categorised by hand
+var backfillPages = [][]firefly.FireFlyTransactionAttributes{
+	{
+		{Id: "1", Attributes: firefly.FireFlyTransactions{Transactions: []firefly.FireFlyTransaction{
+			{TransactionID: "11", Description: "UBER TRIP", Amount: "25.00", Date: "2024-01-02T00:00:00+00:00"},
+		}}},
+	},
+	{
+		{Id: "2", Attributes: firefly.FireFlyTransactions{Transactions: []firefly.FireFlyTransaction{
+			{TransactionID: "21", Description: "WOOLWORTHS METRO", Amount: "40.00", Category: "Household"},
+		}}},
+		{Id: "3", Attributes: firefly.FireFlyTransactions{Transactions: []firefly.FireFlyTransaction{
+			{TransactionID: "31", Description: "COLES SUPERMARKET", Amount: "12.50"},
+		}}},
+	},
+}
+
+// fake firefly server listing backfill pages and recording transaction updates
+func newBackfillServer(t *testing.T) (*httptest.Server, *[]firefly.FireFlyTransactions, *[]int) {
+	var updates []firefly.FireFlyTransactions
+	var pages []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var update firefly.FireFlyTransactions
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&update))
+			updates = append(updates, update)
+			w.Write([]byte(`{}`))
+			return
+		}
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		assert.NoError(t, err)
+		pages = append(pages, page)
+		json.NewEncoder(w).Encode(firefly.FireFlyTransactionsResponse{
+			Data: backfillPages[page-1],
+			Meta: firefly.FireFlyPagination{Pagination: firefly.FireFlyPaginationData{CurrentPage: page, TotalPages: len(backfillPages)}},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &updates, &pages
+}
+
+func newBackfillHandler(t *testing.T, srv *httptest.Server) *WebHookHandler {
+	wh := newTestHandler(t, srv, &config.Config{CategoriesEnabled: true, OverwritePolicy: config.OverwriteOnlyEmpty})
+	wh.BackfillStateFile = filepath.Join(t.TempDir(), "backfill.json")
+	return wh
+}
+
+func TestBackfillDryRun(t *testing.T) {
+	srv, updates, _ := newBackfillServer(t)
+	wh := newBackfillHandler(t, srv)
+
+	var report strings.Builder
+	progress, err := wh.Backfill(context.Background(), BackfillOptions{DryRun: true}, &report)
+	assert.NoError(t, err)
+	assert.Equal(t, BackfillFinished, progress.State)
+	assert.Equal(t, 3, progress.Transactions)
+	assert.Equal(t, 2, progress.Updated)
+	assert.Equal(t, 1, progress.Skipped)
+
+	// nothing is updated and no progress is saved
+	assert.Empty(t, *updates)
+	_, err = loadBackfillProgress(wh.BackfillStateFile)
+	assert.Error(t, err)
+
+	rows, err := csv.NewReader(strings.NewReader(report.String())).ReadAll()
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 4) {
+		assert.Equal(t, backfillReportHeader, rows[0])
+		assert.Equal(t, []string{"1", "11", "2024-01-02T00:00:00+00:00", "UBER TRIP", "25.00"}, rows[1][:5])
+		assert.Equal(t, "Transport", rows[1][6])
+		assert.Equal(t, "would update", rows[1][12])
+		assert.Equal(t, "skipped", rows[2][12])
+		assert.Contains(t, rows[2][13], "category: already set to 'Household'")
+	}
+}
+
+func TestBackfillUncategorised(t *testing.T) {
+	srv, updates, _ := newBackfillServer(t)
+	wh := newBackfillHandler(t, srv)
+
+	progress, err := wh.Backfill(context.Background(), BackfillOptions{Uncategorised: true}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, progress.Transactions)
+	assert.Equal(t, 2, progress.Updated)
+
+	// categorised group is not touched
+	if assert.Len(t, *updates, 2) {
+		assert.Equal(t, "1", (*updates)[0].Id)
+		assert.Equal(t, "Transport", (*updates)[0].Transactions[0].Category)
+		assert.Equal(t, "3", (*updates)[1].Id)
+		assert.Equal(t, "Groceries", (*updates)[1].Transactions[0].Category)
+	}
+
+	saved, err := loadBackfillProgress(wh.BackfillStateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, BackfillFinished, saved.State)
+	assert.Equal(t, 2, saved.Page)
+}
+
+func TestBackfillResume(t *testing.T) {
+	srv, updates, pages := newBackfillServer(t)
+	wh := newBackfillHandler(t, srv)
+
+	// first page was done before backfill was stopped
+	stopped := BackfillProgress{State: BackfillStopped, Page: 1, TotalPages: 2, Transactions: 1, Updated: 1}
+	assert.NoError(t, saveBackfillProgress(wh.BackfillStateFile, stopped))
+
+	progress, err := wh.Backfill(context.Background(), BackfillOptions{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2}, *pages)
+	assert.Len(t, *updates, 1)
+	assert.Equal(t, 3, progress.Transactions)
+	assert.Equal(t, 2, progress.Updated)
+
+	// backfill with other filters starts from first page
+	*pages = nil
+	stopped.State = BackfillFailed
+	assert.NoError(t, saveBackfillProgress(wh.BackfillStateFile, stopped))
+	_, err = wh.Backfill(context.Background(), BackfillOptions{TransactionFilter: firefly.TransactionFilter{Start: "2024-01-01"}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, *pages)
+}
+
+func TestBackfillStopped(t *testing.T) {
+	srv, updates, _ := newBackfillServer(t)
+	wh := newBackfillHandler(t, srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	progress, err := wh.Backfill(ctx, BackfillOptions{}, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, BackfillStopped, progress.State)
+	assert.Empty(t, *updates)
+}
+
+func TestHandleBackfill(t *testing.T) {
+	srv, updates, _ := newBackfillServer(t)
+	wh := newBackfillHandler(t, srv)
+
+	rec := httptest.NewRecorder()
+	wh.HandleBackfill(rec, httptest.NewRequest(http.MethodGet, "/backfill", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	wh.HandleBackfill(rec, httptest.NewRequest(http.MethodPost, "/backfill?rate=fast", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// dry run responds with csv report
+	rec = httptest.NewRecorder()
+	wh.HandleBackfill(rec, httptest.NewRequest(http.MethodPost, "/backfill?dry_run=true&uncategorised=true", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv", rec.Header().Get("Content-Type"))
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+	assert.Empty(t, *updates)
+
+	rec = httptest.NewRecorder()
+	wh.HandleBackfill(rec, httptest.NewRequest(http.MethodGet, "/backfill", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var progress BackfillProgress
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&progress))
+	assert.Equal(t, BackfillFinished, progress.State)
+	assert.True(t, progress.Options.DryRun)
+}
+
+func TestHandleBackfillStop(t *testing.T) {
+	srv, _, _ := newBackfillServer(t)
+	wh := newBackfillHandler(t, srv)
+
+	rec := httptest.NewRecorder()
+	wh.HandleBackfill(rec, httptest.NewRequest(http.MethodDelete, "/backfill", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// backfill waits long for second update slot
+	rec = httptest.NewRecorder()
+	wh.HandleBackfill(rec, httptest.NewRequest(http.MethodPost, "/backfill?rate=0.001", nil))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	rec = httptest.NewRecorder()
+	wh.HandleBackfill(rec, httptest.NewRequest(http.MethodDelete, "/backfill", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var progress BackfillProgress
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&progress))
+	assert.Equal(t, BackfillStopped, progress.State)
+
+	// stopped backfill continues on next run
+	saved, err := loadBackfillProgress(wh.BackfillStateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, BackfillStopped, saved.State)
+	assert.True(t, saved.resumable(BackfillOptions{Rate: DefaultBackfillRate}))
+
+	_, stopped := wh.StopBackfill()
+	assert.False(t, stopped)
+}
+
+func TestBackfillAuthError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "Unauthenticated."}`))
+			return
+		}
+		json.NewEncoder(w).Encode(firefly.FireFlyTransactionsResponse{Data: backfillPages[0]})
+	}))
+	defer srv.Close()
+	wh := newBackfillHandler(t, srv)
+
+	// backfill stops on first rejected update
+	progress, err := wh.Backfill(context.Background(), BackfillOptions{}, nil)
+	assert.True(t, firefly.IsAuthError(err))
+	assert.Equal(t, BackfillFailed, progress.State)
+	assert.Equal(t, "request failed with status: 401: Unauthenticated.", progress.Error)
+	assert.Equal(t, 0, progress.Page)
+}
+
+func TestBackfillRateLimitsUpdates(t *testing.T) {
+	srv, updates := newFireflyServer(t)
+	wh := newTestHandler(t, srv, &config.Config{CategoriesEnabled: true, OverwritePolicy: config.OverwriteOnlyEmpty})
+
+	// next update slot is never reached
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts := groupOptions{
+		policy:  config.OverwriteOnlyEmpty,
+		limiter: &rateLimiter{interval: time.Hour, next: time.Now().Add(time.Hour)},
+	}
+
+	// groups that are not updated do not wait
+	for _, split := range []FireflyTrn{
+		{Id: "11", Description: "SAVINGS", Type: firefly.TypeTransfer},
+		{Id: "12", Description: "WOOLWORTHS METRO", Category: "Household"},
+	} {
+		results, err := wh.classifyGroup(ctx, wh.Classifier(), "1", []FireflyTrn{split}, opts)
+		assert.NoError(t, err)
+		assert.False(t, results[0].Updated)
+	}
+
+	_, err := wh.classifyGroup(ctx, wh.Classifier(), "2", []FireflyTrn{{Id: "21", Description: "UBER TRIP"}}, opts)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, *updates)
+}
@@ -1,29 +1,106 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"ffiiitc/internal/classifier"
 	"ffiiitc/internal/config"
 	"ffiiitc/internal/firefly"
+	"ffiiitc/internal/rules"
+	"ffiiitc/internal/training"
+	"fmt"
 
 	"net/http"
+	"os"
+	"path"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-pkgz/lgr"
 )
 
+const (
+	defaultExplainTop = 3                       // number of top categories in explanation
+	suggestTop        = 3                       // number of top categories suggested in notes
+	suggestionsPrefix = "ffiiitc suggestions: " // line of notes with suggestions starts with it
+	maxLearned        = 10000                   // oldest remembered categories are forgotten above it
+)
+
 type WebHookHandler struct {
-	Classifier    *classifier.TrnClassifier
-	FireflyClient *firefly.FireFlyHttpClient
-	Logger        *lgr.Logger
+	classifier       atomic.Pointer[classifierRef]
+	tagClassifier    atomic.Pointer[classifier.TagClassifier] // nil if tag prediction is disabled
+	budgetClassifier atomic.Pointer[classifierRef]            // nil if budget prediction is disabled
+	FireflyClient    *firefly.FireFlyHttpClient
+	Logger           *lgr.Logger
+	Config           *config.Config
+	TrainingJobs     *training.Manager
+	Rules            *rules.RuleSet // applied before classifier, nil if there are no rules
+	modelLock        sync.Mutex     // serialises model updates and writes of model file
+	// files to save category, tag and budget models to
+	ModelFile       string
+	TagModelFile    string
+	BudgetModelFile string
+	// file to save backfill progress to
+	BackfillStateFile string
+	backfill          backfillStatus
+	// file to save categories learned from update web hook
+	// or set by classification to
+	LearnedStateFile string
+	learned          map[string]learnedCategory // by transaction journal id, guarded by modelLock
+}
+
+// holder of classifier in use, so that classifiers
+// of different backends can be swapped atomically
+type classifierRef struct {
+	cls classifier.Classifier
 }
 
 // structs to handle payload from new transaction web hook
 type FireflyTrn struct {
-	Id          string   `json:"transaction_journal_id"`
-	Description string   `json:"description"`
-	Category    string   `json:"category_name"`
-	Tags        []string `json:"tags"`
+	Id              string      `json:"transaction_journal_id"`
+	Description     string      `json:"description"`
+	Category        string      `json:"category_name"`
+	Budget          string      `json:"budget_name"`
+	Tags            []string    `json:"tags"`
+	Amount          json.Number `json:"amount"`
+	Type            string      `json:"type"`
+	SourceName      string      `json:"source_name"`
+	SourceIBAN      string      `json:"source_iban"`
+	DestinationName string      `json:"destination_name"`
+	DestinationIBAN string      `json:"destination_iban"`
+	CurrencyCode    string      `json:"currency_code"`
+	Date            string      `json:"date"`
+	Notes           string      `json:"notes"`
+}
+
+// convert webhook transaction to classifier transaction
+// date is accepted with or without time
+func (t FireflyTrn) transaction() classifier.Transaction {
+	amount, _ := t.Amount.Float64()
+	date, err := time.Parse(time.RFC3339, t.Date)
+	if err != nil {
+		date, _ = time.Parse(time.DateOnly, t.Date)
+	}
+	return classifier.Transaction{
+		Category:        t.Category,
+		Description:     t.Description,
+		Amount:          amount,
+		Type:            t.Type,
+		SourceName:      t.SourceName,
+		SourceIBAN:      t.SourceIBAN,
+		DestinationName: t.DestinationName,
+		DestinationIBAN: t.DestinationIBAN,
+		Currency:        t.CurrencyCode,
+		Tags:            t.Tags,
+		Budget:          t.Budget,
+		Date:            date,
+	}
 }
 
 type FireFlyContent struct {
@@ -35,12 +112,57 @@ type FireflyWebHook struct {
 	Content FireFlyContent `json:"content"`
 }
 
-func NewWebHookHandler(c *classifier.TrnClassifier, f *firefly.FireFlyHttpClient, l *lgr.Logger) *WebHookHandler {
-	return &WebHookHandler{
-		Classifier:    c,
+func NewWebHookHandler(c classifier.Classifier, f *firefly.FireFlyHttpClient, cfg *config.Config, l *lgr.Logger) *WebHookHandler {
+	wh := &WebHookHandler{
 		FireflyClient: f,
 		Logger:        l,
+		Config:        cfg,
+		TrainingJobs:  training.NewManager(),
+
+		ModelFile:       config.ModelFile,
+		TagModelFile:    config.TagModelFile,
+		BudgetModelFile: config.BudgetModelFile,
+
+		BackfillStateFile: config.BackfillStateFile,
+		LearnedStateFile:  config.LearnedStateFile,
+	}
+	wh.SwapClassifier(c)
+	return wh
+}
+
+// get classifier currently in use
+func (wh *WebHookHandler) Classifier() classifier.Classifier {
+	return wh.classifier.Load().cls
+}
+
+// replace classifier in use with new one
+// requests in flight keep using classifier they already got
+func (wh *WebHookHandler) SwapClassifier(c classifier.Classifier) {
+	wh.classifier.Store(&classifierRef{cls: c})
+}
+
+// get tag classifier currently in use, nil if there is none
+func (wh *WebHookHandler) TagClassifier() *classifier.TagClassifier {
+	return wh.tagClassifier.Load()
+}
+
+// replace tag classifier in use with new one
+func (wh *WebHookHandler) SwapTagClassifier(tc *classifier.TagClassifier) {
+	wh.tagClassifier.Store(tc)
+}
+
+// get budget classifier currently in use, nil if there is none
+func (wh *WebHookHandler) BudgetClassifier() classifier.Classifier {
+	ref := wh.budgetClassifier.Load()
+	if ref == nil {
+		return nil
 	}
+	return ref.cls
+}
+
+// replace budget classifier in use with new one
+func (wh *WebHookHandler) SwapBudgetClassifier(c classifier.Classifier) {
+	wh.budgetClassifier.Store(&classifierRef{cls: c})
 }
 
 // http handler for new transaction
@@ -62,104 +184,799 @@ func (wh *WebHookHandler) HandleNewTransactionWebHook(w http.ResponseWriter, r *
 		return
 	}
 
-	// perform classification
-	for _, trn := range hookData.Content.Transactions {
-		wh.Logger.Logf(
-			"INFO hook new trn: received (id: %v) (description: %s)",
-			hookData.Content.Id,
-			trn.Description,
-		)
-		cat := wh.Classifier.ClassifyTransaction(trn.Description)
-		wh.Logger.Logf("INFO hook new trn: classified (id: %v) (category: %s)", hookData.Content.Id, cat)
-		err = wh.FireflyClient.UpdateTransactionCategory(strconv.FormatInt(hookData.Content.Id, 10), trn.Id, cat, trn.Tags)
+	// perform classification of all splits
+	id := strconv.FormatInt(hookData.Content.Id, 10)
+	wh.Logger.Logf("INFO hook new trn: received (id: %v) (splits: %d)", id, len(hookData.Content.Transactions))
+	// update is finished even if firefly stops waiting for response
+	splits, _ := wh.classifyGroup(context.Background(), wh.Classifier(), id, hookData.Content.Transactions, groupOptions{
+		policy: wh.Config.OverwritePolicy,
+	})
+	writeJSON(w, http.StatusOK, ClassifyResponse{Splits: splits})
+}
+
+// options of transaction group classification
+type groupOptions struct {
+	policy        string       // overwrite policy for category and budget already set
+	uncategorised bool         // only classify splits without category
+	dryRun        bool         // do not update firefly
+	limiter       *rateLimiter // spaces out updates, nil for no limit
+}
+
+// classify all splits of transaction group and update the whole group at once
+// unchanged splits are sent as they are so that firefly keeps them
+// error of the update is also marked in results of updated splits
+// waiting for rate limiter and update are cancelled with context
+func (wh *WebHookHandler) classifyGroup(ctx context.Context, cls classifier.Classifier, id string, splits []FireflyTrn, opts groupOptions) ([]SplitResult, error) {
+	results := []SplitResult{}
+	var updates []firefly.TransactionUpdate
+	applied := make(map[string]string) // categories predicted by model by split
+	updated := false
+	for _, trn := range splits {
+		wh.Logger.Logf("INFO classify: (id: %s) (split: %s) (description: %s)", id, trn.Id, trn.Description)
+		var update firefly.TransactionUpdate
+		var split SplitResult
+		switch {
+		case wh.skippedType(trn.Type):
+			update, split = unchangedSplit(trn), SplitResult{
+				TransactionID: trn.Id,
+				Skipped:       []string{fmt.Sprintf("split: %s is not classified", trn.Type)},
+			}
+		case opts.uncategorised && trn.Category != "":
+			update, split = unchangedSplit(trn), SplitResult{
+				TransactionID: trn.Id,
+				Skipped:       []string{"split: already categorised"},
+			}
+		default:
+			var category string
+			update, split, category = wh.classifySplit(cls, trn, opts.policy)
+			if category != "" {
+				applied[trn.Id] = category
+			}
+		}
+		for _, reason := range split.Skipped {
+			wh.Logger.Logf("INFO classify: skipped %s (id: %s) (split: %s)", reason, id, trn.Id)
+		}
+		updates = append(updates, update)
+		updated = updated || split.Updated
+		results = append(results, split)
+	}
+	if !updated || opts.dryRun {
+		return results, nil
+	}
+	if opts.limiter != nil {
+		err := opts.limiter.wait(ctx)
 		if err != nil {
-			wh.Logger.Logf("ERROR hook new trn: error updating (id: %v) %v", hookData.Content.Id, err)
+			return results, err
+		}
+	}
+
+	err := wh.FireflyClient.WithContext(ctx).UpdateTransactionGroup(id, updates)
+	if err != nil {
+		switch {
+		case firefly.IsAuthError(err):
+			wh.Logger.Logf("ERROR classify: firefly rejected api key, check FF_API_KEY (id: %s) %v", id, err)
+		case firefly.IsValidationError(err):
+			wh.Logger.Logf("WARN classify: firefly rejected update (id: %s) %v", id, err)
+		default:
+			wh.Logger.Logf("ERROR classify: error updating (id: %s) %v", id, err)
+		}
+		for i := range results {
+			if results[i].Updated {
+				results[i].Updated = false
+				results[i].Error = err.Error()
+			}
 		}
-		wh.Logger.Logf("INFO hook new trn: updated (id: %v)", hookData.Content.Id)
+		return results, err
+	}
+	wh.Logger.Logf("INFO classify: updated (id: %s)", id)
+	wh.rememberAppliedCategories(applied)
+	return results, nil
+}
 
+// remember categories predicted by model and set on splits,
+// they are forgotten by model once user corrects them
+func (wh *WebHookHandler) rememberAppliedCategories(applied map[string]string) {
+	if len(applied) == 0 {
+		return
 	}
-	w.WriteHeader(http.StatusOK)
+	wh.modelLock.Lock()
+	defer wh.modelLock.Unlock()
+	for id, category := range applied {
+		wh.rememberCategory(id, category)
+	}
+	err := wh.storeLearnedCategories()
+	if err != nil {
+		wh.Logger.Logf("WARN classify: saving applied categories: %v", err)
+	}
+}
+
+// transfers and types excluded from training are not classified,
+// model knows nothing about them
+func (wh *WebHookHandler) skippedType(trnType string) bool {
+	return strings.EqualFold(trnType, firefly.TypeTransfer) || firefly.HasType(trnType, wh.Config.TrainingExcludedTypes)
+}
+
+// update keeping split as it is
+func unchangedSplit(trn FireflyTrn) firefly.TransactionUpdate {
+	return firefly.TransactionUpdate{
+		TransactionID: trn.Id,
+		Description:   trn.Description,
+		Tags:          mergeTags(trn.Tags, nil),
+	}
+}
+
+// outcome of transaction split classification
+// category, budget and tags are the ones set on split,
+// confidence and rule are of predicted category even if it is not set,
+// suggestions are top categories written to notes instead of setting category,
+// skipped lists reasons why category, budget or whole split were not updated
+type SplitResult struct {
+	TransactionID string                     `json:"transaction_journal_id"`
+	Updated       bool                       `json:"updated"`
+	Category      string                     `json:"category,omitempty"`
+	Budget        string                     `json:"budget,omitempty"`
+	Tags          []string                   `json:"tags,omitempty"`
+	Predicted     string                     `json:"predicted,omitempty"`
+	Confidence    float64                    `json:"confidence,omitempty"`
+	Rule          string                     `json:"rule,omitempty"`
+	Suggestions   []classifier.CategoryScore `json:"suggestions,omitempty"`
+	Skipped       []string                   `json:"skipped,omitempty"`
+	Error         string                     `json:"error,omitempty"`
+}
+
+// response to new transaction web hook
+type ClassifyResponse struct {
+	Splits []SplitResult `json:"splits"`
+}
+
+// classify transaction split and decide what to update
+// according to confidence thresholds and overwrite policy
+// category predicted by model is also returned if it is set on split
+func (wh *WebHookHandler) classifySplit(cls classifier.Classifier, trn FireflyTrn, policy string) (firefly.TransactionUpdate, SplitResult, string) {
+	res := wh.classify(cls, trn.transaction())
+	if res.Rule != "" {
+		wh.Logger.Logf("INFO classify: matched rule '%s' (split: %s)", res.Rule, trn.Id)
+	}
+	wh.Logger.Logf("INFO classify: classified (split: %s) (category: %s) (confidence: %.2f) (tags: %v)", trn.Id, res.Category, res.Confidence, res.Tags)
+	if res.Budget != "" {
+		wh.Logger.Logf("INFO classify: classified (split: %s) (budget: %s) (confidence: %.2f)", trn.Id, res.Budget, res.BudgetConfidence)
+	}
+
+	split := SplitResult{
+		TransactionID: trn.Id,
+		Predicted:     res.Category,
+		Confidence:    res.Confidence,
+		Rule:          res.Rule,
+	}
+	update := firefly.TransactionUpdate{
+		TransactionID: trn.Id,
+		Description:   trn.Description,
+		Tags:          mergeTags(trn.Tags, res.Tags),
+	}
+	if wh.Config.CategoriesEnabled {
+		if wh.suggests(res) {
+			// leave category for user to confirm
+			if ok, reason := overwrite(policy, trn.Category, res.Category, res.Confidence, res.Scores); ok {
+				split.Suggestions = res.Scores
+				if len(split.Suggestions) > suggestTop {
+					split.Suggestions = split.Suggestions[:suggestTop]
+				}
+				update.Notes = suggestionNotes(trn.Notes, split.Suggestions)
+				if wh.Config.SuggestTag != "" {
+					update.Tags = mergeTags(update.Tags, []string{wh.Config.SuggestTag})
+				}
+				split.Skipped = append(split.Skipped, "category: suggested in notes")
+			} else {
+				split.Skipped = append(split.Skipped, "category: "+reason)
+			}
+		} else if res.Confidence < wh.Config.MinConfidence {
+			// do not write a guess, leave category empty
+			split.Skipped = append(split.Skipped, fmt.Sprintf("category: confidence %.2f below %.2f", res.Confidence, wh.Config.MinConfidence))
+			if wh.Config.ReviewTag != "" && trn.Category == "" {
+				update.Tags = mergeTags(update.Tags, []string{wh.Config.ReviewTag})
+			}
+		} else if ok, reason := overwrite(policy, trn.Category, res.Category, res.Confidence, res.Scores); ok {
+			update.Category = res.Category
+		} else {
+			split.Skipped = append(split.Skipped, "category: "+reason)
+		}
+	}
+	if res.Budget != "" {
+		if res.BudgetConfidence < wh.Config.BudgetMinConfidence {
+			split.Skipped = append(split.Skipped, fmt.Sprintf("budget: confidence %.2f below %.2f", res.BudgetConfidence, wh.Config.BudgetMinConfidence))
+		} else if ok, reason := overwrite(policy, trn.Budget, res.Budget, res.BudgetConfidence, res.BudgetScores); ok {
+			update.Budget = res.Budget
+		} else {
+			split.Skipped = append(split.Skipped, "budget: "+reason)
+		}
+	}
+
+	split.Category, split.Budget = update.Category, update.Budget
+	split.Tags = update.Tags[len(trn.Tags):]
+	split.Updated = update.Category != "" || update.Budget != "" || update.Notes != "" || len(split.Tags) > 0
+	if !split.Updated {
+		split.Skipped = append(split.Skipped, "split: nothing to update")
+	}
+	applied := ""
+	if len(res.Scores) > 0 {
+		applied = update.Category
+	}
+	return update, split, applied
+}
+
+// check if predicted category is suggested instead of set
+// category of rule is always set
+func (wh *WebHookHandler) suggests(res classification) bool {
+	if len(res.Scores) == 0 {
+		return false
+	}
+	switch wh.Config.SuggestMode {
+	case config.SuggestAlways:
+		return true
+	case config.SuggestLowConfidence:
+		return res.Confidence < wh.Config.MinConfidence
+	default:
+		return false
+	}
+}
+
+// add line with suggested categories to notes
+// line of previous suggestions is replaced, rest of notes is kept
+func suggestionNotes(notes string, suggestions []classifier.CategoryScore) string {
+	var lines []string
+	if notes != "" {
+		for _, line := range strings.Split(notes, "\n") {
+			if !strings.HasPrefix(line, suggestionsPrefix) {
+				lines = append(lines, line)
+			}
+		}
+	}
+	lines = append(lines, suggestionsPrefix+formatSuggestions(suggestions))
+	return strings.Join(lines, "\n")
+}
+
+// format suggestions as "Groceries 82%, Dining 10%"
+func formatSuggestions(suggestions []classifier.CategoryScore) string {
+	items := make([]string, 0, len(suggestions))
+	for _, s := range suggestions {
+		items = append(items, fmt.Sprintf("%s %.0f%%", s.Category, s.Probability*100))
+	}
+	return strings.Join(items, ", ")
+}
+
+// decide if predicted value replaces value already set on transaction
+// according to overwrite policy, returns reason if it does not
+func overwrite(policy, existing, predicted string, confidence float64, scores []classifier.CategoryScore) (bool, string) {
+	if existing == "" {
+		return true, ""
+	}
+	if existing == predicted {
+		return false, fmt.Sprintf("already set to '%s'", existing)
+	}
+	switch policy {
+	case config.OverwriteAlways:
+		return true, ""
+	case config.OverwriteIfMoreConfident:
+		// values never learned by model are least likely
+		existingProb := 0.0
+		for _, score := range scores {
+			if score.Category == existing {
+				existingProb = score.Probability
+			}
+		}
+		if confidence > existingProb {
+			return true, ""
+		}
+		return false, fmt.Sprintf("'%s' (%.2f) is not more likely than existing '%s' (%.2f)", predicted, confidence, existing, existingProb)
+	default:
+		return false, fmt.Sprintf("already set to '%s'", existing)
+	}
+}
+
+// result of transaction classification by rules and classifier
+type classification struct {
+	Category   string
+	Confidence float64
+	Rule       string                     // name of matched rule, empty if none matched
+	Tags       []string                   // tags assigned by rule and tag classifier
+	Scores     []classifier.CategoryScore // empty if category is set by rule
+	// budget is empty if budget prediction is disabled
+	Budget           string
+	BudgetConfidence float64
+	BudgetScores     []classifier.CategoryScore
+}
+
+// classify transaction with rules first
+// category of matched rule is applied with full confidence,
+// if there is no rule or it only assigns tags classifier decides
+// predicted tags are added to the ones assigned by rule
+func (wh *WebHookHandler) classify(cls classifier.Classifier, t classifier.Transaction) classification {
+	var res classification
+	rule, ok := wh.Rules.Match(t)
+	if ok {
+		res.Rule = rule.Name
+		res.Tags = rule.Tags
+	}
+	if ok && rule.Category != "" {
+		res.Category = rule.Category
+		res.Confidence = 1
+	} else {
+		pred := cls.Predict(t)
+		res.Category, res.Confidence, res.Scores = pred.Category, pred.Probability, pred.Scores
+	}
+	if tc := wh.TagClassifier(); tc != nil {
+		res.Tags = mergeTags(res.Tags, tc.PredictTags(t, wh.Config.TagThreshold))
+	}
+	if bc := wh.BudgetClassifier(); bc != nil {
+		pred := bc.Predict(t)
+		res.Budget, res.BudgetConfidence, res.BudgetScores = pred.Category, pred.Probability, pred.Scores
+	}
+	return res
+}
+
+// add new tags to existing ones skipping duplicates
+func mergeTags(tags, newTags []string) []string {
+	res := append([]string{}, tags...)
+	for _, tag := range newTags {
+		if !slices.Contains(res, tag) {
+			res = append(res, tag)
+		}
+	}
+	return res
 }
 
 // http handler for forcing to train model
+// training runs in background, response contains training job
 func (wh *WebHookHandler) HandleForceTrainingModel(w http.ResponseWriter, r *http.Request) {
 
+	// only allow post method
+	if r.Method != http.MethodPost {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	startStr := r.FormValue("start")
+	endStr := r.FormValue("end")
+
+	wh.Logger.Logf("INFO Received request to perform force training")
+	job, err := wh.TrainingJobs.Start(startStr, endStr, func(p *training.Progress) error {
+		return wh.trainModel(p, startStr, endStr)
+	})
+	if errors.Is(err, training.ErrJobRunning) {
+		wh.Logger.Logf("WARN training job %s is already running", job.Id)
+		writeJSON(w, http.StatusConflict, job)
+		return
+	}
+	wh.Logger.Logf("INFO training job %s started", job.Id)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// http handler for training job status
+// job id is taken from path /train/{id}
+func (wh *WebHookHandler) HandleTrainingStatus(w http.ResponseWriter, r *http.Request) {
+
 	// only allow get method
 	if r.Method != http.MethodGet {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
-	query := r.URL.Query()
-	startStr := query.Get("start")
-	endStr := query.Get("end")
+	id := path.Base(r.URL.Path)
+	job, exist := wh.TrainingJobs.Get(id)
+	if !exist {
+		http.Error(w, "training job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
 
-	wh.Logger.Logf("INFO Received request to perform force training")
+// request payload for classification explanation
+// transaction fields are the same as in web hook,
+// fields other than description are optional
+type ExplainRequest struct {
+	FireflyTrn
+	Top int `json:"top"`
+}
+
+// http handler for explaining classification of transaction description
+func (wh *WebHookHandler) HandleExplain(w http.ResponseWriter, r *http.Request) {
+
+	// only allow post method
+	if r.Method != http.MethodPost {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var req ExplainRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		wh.Logger.Logf("ERROR decoding explain payload: %v", err)
+		http.Error(w, "bad data", http.StatusBadRequest)
+		return
+	}
+	if req.Description == "" {
+		wh.Logger.Logf("WARN explain payload has no description")
+		http.Error(w, "no description provided", http.StatusBadRequest)
+		return
+	}
+	if req.Top <= 0 {
+		req.Top = defaultExplainTop
+	}
+
+	explainer, ok := wh.Classifier().(classifier.Explainer)
+	if !ok {
+		http.Error(w, "classifier backend does not support explanation", http.StatusNotImplemented)
+		return
+	}
+	explanation := explainer.ExplainTransaction(req.transaction(), req.Top)
+	wh.Logger.Logf("DEBUG explain (description: %s) %+v", req.Description, explanation)
+	writeJSON(w, http.StatusOK, explanation)
+}
+
+// request payload for dry run classification
+// either single description, batch of descriptions
+// or batch of transactions with amount, type, accounts...
+// in the same format as in web hook
+type PredictRequest struct {
+	Description  string       `json:"description"`
+	Descriptions []string     `json:"descriptions"`
+	Transactions []FireflyTrn `json:"transactions"`
+}
+
+// predicted category of transaction description
+// confident is false if webhook would not apply category
+// because of confidence threshold
+// rule is name of matched rule, if any
+type Prediction struct {
+	Description      string   `json:"description"`
+	Category         string   `json:"category"`
+	Confidence       float64  `json:"confidence"`
+	Confident        bool     `json:"confident"`
+	Rule             string   `json:"rule,omitempty"`
+	Tags             []string `json:"tags,omitempty"`
+	Budget           string   `json:"budget,omitempty"`
+	BudgetConfidence float64  `json:"budget_confidence,omitempty"`
+}
+
+type PredictResponse struct {
+	Predictions []Prediction `json:"predictions"`
+}
+
+// http handler for dry run classification
+// nothing is written back to firefly
+func (wh *WebHookHandler) HandlePredict(w http.ResponseWriter, r *http.Request) {
+
+	// only allow post method
+	if r.Method != http.MethodPost {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var req PredictRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		wh.Logger.Logf("ERROR decoding predict payload: %v", err)
+		http.Error(w, "bad data", http.StatusBadRequest)
+		return
+	}
+	var transactions []classifier.Transaction
+	if req.Description != "" {
+		transactions = append(transactions, classifier.Transaction{Description: req.Description})
+	}
+	for _, desc := range req.Descriptions {
+		transactions = append(transactions, classifier.Transaction{Description: desc})
+	}
+	for _, trn := range req.Transactions {
+		transactions = append(transactions, trn.transaction())
+	}
+	if len(transactions) == 0 {
+		http.Error(w, "no transactions provided", http.StatusBadRequest)
+		return
+	}
+
+	cls := wh.Classifier()
+	res := PredictResponse{Predictions: []Prediction{}}
+	for _, trn := range transactions {
+		c := wh.classify(cls, trn)
+		res.Predictions = append(res.Predictions, Prediction{
+			Description: trn.Description,
+			Category:    c.Category,
+			Confidence:  c.Confidence,
+			Confident:   c.Confidence >= wh.Config.MinConfidence,
+			Rule:        c.Rule,
+			Tags:        c.Tags,
+
+			Budget:           c.Budget,
+			BudgetConfidence: c.BudgetConfidence,
+		})
+	}
+	wh.Logger.Logf("INFO predicted categories for %d transactions", len(res.Predictions))
+	writeJSON(w, http.StatusOK, res)
+}
+
+// fetch transactions from firefly, train new model,
+// save it and swap with model in use
+func (wh *WebHookHandler) trainModel(p *training.Progress, startStr, endStr string) error {
 	wh.Logger.Logf("INFO Requesting transactions data from Firefly")
-	trnDataset, err := wh.FireflyClient.GetTransactionsDataset(startStr, endStr)
-	if err != nil || len(trnDataset) == 0 {
+	p.SetState(training.StateFetching)
+	trnDataset, err := wh.FireflyClient.GetTransactionsDatasetWithProgress(startStr, endStr, p.SetPage)
+	if err != nil {
 		wh.Logger.Logf("ERROR: Error while getting transactions data\n %v", err)
-	} else {
-		wh.Logger.Logf("DEBUG Got training data\n %v", trnDataset)
-		cls, err := classifier.NewTrnClassifierWithTraining(trnDataset, wh.Logger)
+		return fmt.Errorf("getting transactions data: %w", err)
+	}
+	if len(trnDataset) == 0 {
+		wh.Logger.Logf("ERROR: No transactions data received")
+		return errors.New("no transactions data received")
+	}
+
+	wh.Logger.Logf("DEBUG Got training data\n %v", trnDataset)
+	p.SetState(training.StateTraining)
+	categorised, summary, err := classifier.ValidateDataSet(trnDataset, wh.Config.Validation)
+	p.SetDataSet(summary)
+	if err != nil {
+		wh.Logger.Logf("ERROR validating dataset: %v", err)
+		return err
+	}
+	wh.Logger.Logf("INFO data set: %s", summary)
+	cls, err := classifier.NewClassifierWithTraining(wh.Config.Backend, categorised, wh.Config.Features, wh.Logger)
+	if err != nil {
+		wh.Logger.Logf("ERROR creating classifier from dataset:\n %v", err)
+		return fmt.Errorf("creating classifier from dataset: %w", err)
+	}
+	p.SetCounts(len(categorised), len(cls.Describe().Categories))
+
+	// tag model is optional, transactions may have no tags yet
+	var tagCls *classifier.TagClassifier
+	if wh.Config.TagsEnabled {
+		tagCls, err = classifier.NewTagClassifierWithTraining(trnDataset, wh.Config.IgnoredTags(), wh.Config.Features, wh.Logger)
 		if err != nil {
-			wh.Logger.Logf("ERROR creating classifier from dataset:\n %v", err)
-		} else {
-			wh.Logger.Logf("INFO forced training completed...")
-			wh.Logger.Logf("INFO saving data to model...")
-			err = cls.SaveClassifierToFile(config.ModelFile)
-			if err != nil {
-				wh.Logger.Logf("ERROR saving model to file:\n %v", err)
-			} else {
-				wh.Logger.Logf("INFO: forced training completed and model saved. Please restart 'ffiiitc' container")
-			}
+			wh.Logger.Logf("WARN tag model is not trained: %v", err)
 		}
 	}
 
-	w.WriteHeader(http.StatusOK)
+	// budget model is optional as well
+	var budgetCls classifier.Classifier
+	if wh.Config.BudgetsEnabled {
+		budgetCls, err = classifier.NewClassifierWithTraining(wh.Config.Backend, classifier.BudgetDataSet(trnDataset), wh.Config.Features, wh.Logger)
+		if err != nil {
+			wh.Logger.Logf("WARN budget model is not trained: %v", err)
+		}
+	}
+
+	wh.Logger.Logf("INFO forced training completed...")
+	wh.Logger.Logf("INFO saving data to model...")
+	wh.modelLock.Lock()
+	defer wh.modelLock.Unlock()
+	err = cls.Save(wh.ModelFile)
+	if err != nil {
+		wh.Logger.Logf("ERROR saving model to file:\n %v", err)
+		return fmt.Errorf("saving model to file: %w", err)
+	}
+	if tagCls != nil {
+		err = tagCls.Save(wh.TagModelFile)
+		if err != nil {
+			wh.Logger.Logf("ERROR saving tag model to file:\n %v", err)
+			return fmt.Errorf("saving tag model to file: %w", err)
+		}
+		wh.SwapTagClassifier(tagCls)
+	}
+	if budgetCls != nil {
+		err = budgetCls.Save(wh.BudgetModelFile)
+		if err != nil {
+			wh.Logger.Logf("ERROR saving budget model to file:\n %v", err)
+			return fmt.Errorf("saving budget model to file: %w", err)
+		}
+		wh.SwapBudgetClassifier(budgetCls)
+	}
+	wh.SwapClassifier(cls)
+	wh.resetLearnedCategories()
+	wh.Logger.Logf("INFO forced training completed, model saved and is now in use")
+	return nil
+}
+
+// http handler describing model in use
+func (wh *WebHookHandler) HandleModelInfo(w http.ResponseWriter, r *http.Request) {
+
+	// only allow get method
+	if r.Method != http.MethodGet {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, wh.Classifier().Describe())
+}
+
+// write value as json response with given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
 }
 
 // http handler for updated transaction
-// func (wh *WebHookHandler) HandleUpdateTransactionWebHook(w http.ResponseWriter, r *http.Request) {
-
-// 	// only allow post method
-// 	if r.Method != http.MethodPost {
-// 		http.Error(w, "bad request", http.StatusBadRequest)
-// 		return
-// 	}
-
-// 	// decode payload
-// 	decoder := json.NewDecoder(r.Body)
-// 	var hookData FireflyWebHook
-// 	err := decoder.Decode(&hookData)
-// 	if err != nil {
-// 		http.Error(w, "bad data", http.StatusBadRequest)
-// 		return
-// 	}
-
-// 	// perform training
-// 	for _, trn := range hookData.Content.Transactions {
-// 		wh.Logger.Logf(
-// 			"hook update trn: received (id: %v) (desc: %s) (cat: %s)",
-// 			trn.Id,
-// 			trn.Description,
-// 			trn.Category,
-// 		)
-
-// 		if trn.Category != "" {
-// 			err := wh.Classifier.Train(trn.Description, trn.Category)
-// 			if err != nil {
-// 				wh.Logger.Logf("hook update trn: error updating model: %v", err)
-// 			}
-// 			wh.Logger.Logf(
-// 				"hook update trn: (cat: %s) (features: %v)",
-// 				trn.Category,
-// 				wh.Classifier.Classifier.WordsByClass(bayesian.Class(trn.Category)),
-// 			)
-// 		} else {
-// 			wh.Logger.Logf("skip training. Category is empty")
-// 		}
-
-// 	// }
-// 	w.WriteHeader(http.StatusOK)
-// }
+// learns corrected category of transaction
+func (wh *WebHookHandler) HandleUpdateTransactionWebHook(w http.ResponseWriter, r *http.Request) {
+
+	// only allow post method
+	if r.Method != http.MethodPost {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	// decode payload
+	decoder := json.NewDecoder(r.Body)
+	var hookData FireflyWebHook
+	err := decoder.Decode(&hookData)
+	if err != nil {
+		wh.Logger.Logf("ERROR decoding webhook payload: %v", err)
+		http.Error(w, "bad data", http.StatusBadRequest)
+		return
+	}
+
+	// perform training
+	// model lock is held for the whole update so that
+	// forced training can not swap model in the middle of it
+	wh.modelLock.Lock()
+	defer wh.modelLock.Unlock()
+	cls := wh.Classifier()
+	learner, ok := cls.(classifier.OnlineLearner)
+	if !ok {
+		wh.Logger.Logf("WARN hook update trn: classifier backend does not support online learning")
+		http.Error(w, "classifier backend does not support online learning", http.StatusNotImplemented)
+		return
+	}
+	learned := false
+	for _, trn := range hookData.Content.Transactions {
+		wh.Logger.Logf(
+			"INFO hook update trn: received (id: %v) (description: %s) (category: %s)",
+			hookData.Content.Id,
+			trn.Description,
+			trn.Category,
+		)
+		if trn.Category == "" {
+			wh.Logger.Logf("INFO hook update trn: skip training, category is empty (id: %v)", hookData.Content.Id)
+			continue
+		}
+		if wh.skippedType(trn.Type) {
+			wh.Logger.Logf("INFO hook update trn: skip training, %s is not learned (id: %v)", trn.Type, hookData.Content.Id)
+			continue
+		}
+		if wh.Config.Validation.Ignores(trn.Category) {
+			wh.Logger.Logf("INFO hook update trn: skip training, category is ignored (id: %v)", hookData.Content.Id)
+			continue
+		}
+
+		// firefly sends update for any change, not only of category,
+		// so category already learned or set by ffiiitc for transaction
+		// is not learned again and is forgotten when category changes
+		remembered, known := wh.learnedCategories()[trn.Id]
+		oldCat := remembered.Category
+		if known && oldCat == trn.Category {
+			wh.Logger.Logf("INFO hook update trn: skip training, category already learned (id: %v)", hookData.Content.Id)
+			continue
+		}
+
+		// firefly does not send previous category with update,
+		// if it is not remembered we only learn new category
+		// unless model already predicts it
+		// categories set by rules were never learned by model
+		rule, ok := wh.Rules.Match(trn.transaction())
+		byRule := ok && rule.Category != "" && slices.Contains(trn.Tags, firefly.ClassifiedTag)
+		switch {
+		case known:
+			// category learned or set before is forgotten
+		case byRule && rule.Category == trn.Category:
+			wh.Logger.Logf("INFO hook update trn: skip training, category set by rule '%s' (id: %v)", rule.Name, hookData.Content.Id)
+			continue
+		case byRule:
+			// category of rule is corrected
+		case cls.Predict(trn.transaction()).Category == trn.Category:
+			wh.Logger.Logf("INFO hook update trn: skip training, category is already predicted (id: %v)", hookData.Content.Id)
+			continue
+		}
+
+		learner.Relearn(trn.transaction(), oldCat, trn.Category)
+		wh.rememberCategory(trn.Id, trn.Category)
+		learned = true
+		wh.Logger.Logf(
+			"INFO hook update trn: learned (id: %v) (old category: %s) (new category: %s)",
+			hookData.Content.Id,
+			oldCat,
+			trn.Category,
+		)
+	}
+
+	if learned {
+		err = cls.Save(wh.ModelFile)
+		if err != nil {
+			wh.Logger.Logf("ERROR hook update trn: saving model to file: %v", err)
+			http.Error(w, "error saving model", http.StatusInternalServerError)
+			return
+		}
+		err = wh.storeLearnedCategories()
+		if err != nil {
+			wh.Logger.Logf("WARN hook update trn: saving learned categories: %v", err)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// category remembered for transaction, either learned
+// from update web hook or set by classification
+type learnedCategory struct {
+	Category string    `json:"category"`
+	Updated  time.Time `json:"updated"`
+}
+
+// get categories learned from update web hook or set by classification
+// by transaction journal id
+// they are loaded from file on first use, caller must hold model lock
+func (wh *WebHookHandler) learnedCategories() map[string]learnedCategory {
+	if wh.learned == nil {
+		learned, err := loadLearnedCategories(wh.LearnedStateFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			wh.Logger.Logf("WARN loading learned categories: %v", err)
+		}
+		if learned == nil {
+			learned = make(map[string]learnedCategory)
+		}
+		wh.learned = learned
+	}
+	return wh.learned
+}
+
+// remember category of transaction, caller must hold model lock
+func (wh *WebHookHandler) rememberCategory(id, category string) {
+	wh.learnedCategories()[id] = learnedCategory{Category: category, Updated: time.Now()}
+}
+
+// save remembered categories to file, so that it does not grow
+// without bound the oldest ones are forgotten, caller must hold model lock
+func (wh *WebHookHandler) storeLearnedCategories() error {
+	pruneLearnedCategories(wh.learnedCategories(), maxLearned)
+	return saveLearnedCategories(wh.LearnedStateFile, wh.learned)
+}
+
+// forget learned categories once model is trained from scratch
+// caller must hold model lock
+func (wh *WebHookHandler) resetLearnedCategories() {
+	wh.learned = make(map[string]learnedCategory)
+	err := os.Remove(wh.LearnedStateFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		wh.Logger.Logf("WARN removing learned categories: %v", err)
+	}
+}
+
+// forget the oldest categories above limit
+func pruneLearnedCategories(learned map[string]learnedCategory, limit int) {
+	if len(learned) <= limit {
+		return
+	}
+	ids := make([]string, 0, len(learned))
+	for id := range learned {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return learned[ids[i]].Updated.Before(learned[ids[j]].Updated)
+	})
+	for _, id := range ids[:len(ids)-limit] {
+		delete(learned, id)
+	}
+}
+
+func loadLearnedCategories(name string) (map[string]learnedCategory, error) {
+	var learned map[string]learnedCategory
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &learned)
+	return learned, err
+}
+
+func saveLearnedCategories(name string, learned map[string]learnedCategory) error {
+	data, err := json.MarshalIndent(learned, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0644)
+}
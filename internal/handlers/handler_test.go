@@ -0,0 +1,694 @@
+package handlers
+
+import (
+	"encoding/json"
+	"ffiiitc/internal/classifier"
+	"ffiiitc/internal/config"
+	"ffiiitc/internal/firefly"
+	"ffiiitc/internal/rules"
+	"ffiiitc/internal/training"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/lgr"
+	"github.com/stretchr/testify/assert"
+)
+
+var testDataSet = classifier.TransactionDataSet{
+	{Category: "Groceries", Budget: "Food", Description: "WOOLWORTHS METRO"},
+	{Category: "Groceries", Budget: "Food", Description: "COLES SUPERMARKET"},
+	{Category: "Transport", Budget: "Travel", Description: "UBER TRIP"},
+	{Category: "Transport", Budget: "Travel", Description: "OPAL TOPUP"},
+}
+
+// fake firefly server recording bodies of transaction updates
+func newFireflyServer(t *testing.T) (*httptest.Server, *[]firefly.FireFlyTransactions) {
+	var updates []firefly.FireFlyTransactions
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var update firefly.FireFlyTransactions
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&update))
+			updates = append(updates, update)
+		}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &updates
+}
+
+func newTestHandler(t *testing.T, srv *httptest.Server, cfg *config.Config) *WebHookHandler {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	cls, err := classifier.NewClassifierWithTraining(classifier.BackendMultinomial, testDataSet, classifier.FeatureOptions{}, logger)
+	assert.NoError(t, err)
+	fc := firefly.NewFireFlyHttpClient(srv.URL, "token", config.FireflyAppTimeout, logger)
+	wh := NewWebHookHandler(cls, fc, cfg, logger)
+	dir := t.TempDir()
+	wh.ModelFile = filepath.Join(dir, "model.gob")
+	wh.TagModelFile = filepath.Join(dir, "tags.gob")
+	wh.BudgetModelFile = filepath.Join(dir, "budgets.gob")
+	wh.BackfillStateFile = filepath.Join(dir, "backfill.json")
+	wh.LearnedStateFile = filepath.Join(dir, "learned.json")
+	return wh
+}
+
+func TestNewTransactionWebHookBudget(t *testing.T) {
+	srv, updates := newFireflyServer(t)
+	wh := newTestHandler(t, srv, &config.Config{CategoriesEnabled: true, BudgetsEnabled: true})
+	budgets, err := classifier.NewClassifierWithTraining(classifier.BackendMultinomial, classifier.BudgetDataSet(testDataSet), classifier.FeatureOptions{}, wh.Logger)
+	assert.NoError(t, err)
+	wh.SwapBudgetClassifier(budgets)
+
+	payload := `{"content": {"id": 1, "transactions": [{"transaction_journal_id": "2", "description": "UBER TRIP", "tags": ["work"]}]}}`
+	rec := httptest.NewRecorder()
+	wh.HandleNewTransactionWebHook(rec, httptest.NewRequest(http.MethodPost, "/classify", strings.NewReader(payload)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// category and budget are set with single request
+	if assert.Len(t, *updates, 1) {
+		split := (*updates)[0].Transactions[0]
+		assert.Equal(t, "2", split.TransactionID)
+		assert.Equal(t, "Transport", split.Category)
+		assert.Equal(t, "Travel", split.Budget)
+		assert.Equal(t, []string{"work", firefly.ClassifiedTag}, split.Tags)
+	}
+}
+
+func TestNewTransactionWebHookOnlyBudget(t *testing.T) {
+	srv, updates := newFireflyServer(t)
+	wh := newTestHandler(t, srv, &config.Config{BudgetsEnabled: true})
+	budgets, err := classifier.NewClassifierWithTraining(classifier.BackendMultinomial, classifier.BudgetDataSet(testDataSet), classifier.FeatureOptions{}, wh.Logger)
+	assert.NoError(t, err)
+	wh.SwapBudgetClassifier(budgets)
+
+	payload := `{"content": {"id": 1, "transactions": [{"transaction_journal_id": "2", "description": "UBER TRIP", "category_name": "Work"}]}}`
+	rec := httptest.NewRecorder()
+	wh.HandleNewTransactionWebHook(rec, httptest.NewRequest(http.MethodPost, "/classify", strings.NewReader(payload)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// category is not set by ffiiitc, so split is not tagged
+	if assert.Len(t, *updates, 1) {
+		split := (*updates)[0].Transactions[0]
+		assert.Equal(t, "Travel", split.Budget)
+		assert.Empty(t, split.Category)
+		assert.Empty(t, split.Tags)
+	}
+	assert.NoFileExists(t, wh.LearnedStateFile)
+}
+
+func TestNewTransactionWebHookCategoriesDisabled(t *testing.T) {
+	srv, updates := newFireflyServer(t)
+	wh := newTestHandler(t, srv, &config.Config{})
+
+	payload := `{"content": {"id": 1, "transactions": [{"transaction_journal_id": "2", "description": "UBER TRIP"}]}}`
+	rec := httptest.NewRecorder()
+	wh.HandleNewTransactionWebHook(rec, httptest.NewRequest(http.MethodPost, "/classify", strings.NewReader(payload)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, *updates)
+}
+
+func TestNewTransactionWebHookOverwritePolicy(t *testing.T) {
+	tests := []struct {
+		policy      string
+		description string
+		category    string
+		updated     string
+	}{
+		{config.OverwriteOnlyEmpty, "UBER TRIP", "", "Transport"},
+		{config.OverwriteOnlyEmpty, "UBER TRIP", "Groceries", ""},
+		{config.OverwriteAlways, "UBER TRIP", "Groceries", "Transport"},
+		{config.OverwriteIfMoreConfident, "UBER TRIP", "Groceries", "Transport"},
+		{config.OverwriteIfMoreConfident, "UBER TRIP", "Unknown", "Transport"},
+		// nothing is known about description, both categories are equally likely
+		{config.OverwriteIfMoreConfident, "NEW SHOP", "Transport", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy+" "+tt.category, func(t *testing.T) {
+			srv, updates := newFireflyServer(t)
+			wh := newTestHandler(t, srv, &config.Config{CategoriesEnabled: true, OverwritePolicy: tt.policy})
+
+			payload, err := json.Marshal(FireflyWebHook{Content: FireFlyContent{Id: 1, Transactions: []FireflyTrn{
+				{Id: "2", Description: tt.description, Category: tt.category},
+			}}})
+			assert.NoError(t, err)
+			rec := httptest.NewRecorder()
+			wh.HandleNewTransactionWebHook(rec, httptest.NewRequest(http.MethodPost, "/classify", strings.NewReader(string(payload))))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			var res ClassifyResponse
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Len(t, res.Splits, 1)
+			if tt.updated == "" {
+				assert.Empty(t, *updates)
+				assert.False(t, res.Splits[0].Updated)
+				assert.NotEmpty(t, res.Splits[0].Skipped)
+				return
+			}
+			if assert.Len(t, *updates, 1) {
+				assert.Equal(t, tt.updated, (*updates)[0].Transactions[0].Category)
+			}
+			assert.True(t, res.Splits[0].Updated)
+			assert.Equal(t, tt.updated, res.Splits[0].Category)
+		})
+	}
+}
+
+// transaction group with two splits, second one is already categorised
+const splitWebHook = `{
+  "content": {
+    "id": 10,
+    "group_title": "Shopping trip",
+    "transactions": [
+      {"transaction_journal_id": "21", "description": "UBER TRIP", "amount": "25.00", "type": "withdrawal", "tags": []},
+      {"transaction_journal_id": "22", "description": "WOOLWORTHS METRO", "amount": "40.00", "type": "withdrawal", "category_name": "Household", "tags": ["weekly"]}
+    ]
+  }
+}`
+
+func TestNewTransactionWebHookSplits(t *testing.T) {
+	srv, updates := newFireflyServer(t)
+	wh := newTestHandler(t, srv, &config.Config{CategoriesEnabled: true, OverwritePolicy: config.OverwriteOnlyEmpty})
+
+	rec := httptest.NewRecorder()
+	wh.HandleNewTransactionWebHook(rec, httptest.NewRequest(http.MethodPost, "/classify", strings.NewReader(splitWebHook)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// whole group is updated with single request keeping all splits in order
+	if assert.Len(t, *updates, 1) {
+		update := (*updates)[0]
+		assert.Equal(t, "10", update.Id)
+		assert.False(t, update.FireWebHooks)
+		if assert.Len(t, update.Transactions, 2) {
+			first, second := update.Transactions[0], update.Transactions[1]
+			assert.Equal(t, "21", first.TransactionID)
+			assert.Equal(t, "UBER TRIP", first.Description)
+			assert.Equal(t, "Transport", first.Category)
+			assert.Equal(t, []string{firefly.ClassifiedTag}, first.Tags)
+
+			// existing category is kept
+			assert.Equal(t, "22", second.TransactionID)
+			assert.Equal(t, "WOOLWORTHS METRO", second.Description)
+			assert.Empty(t, second.Category)
+			assert.Equal(t, []string{"weekly"}, second.Tags)
+		}
+	}
+
+	var res ClassifyResponse
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	if assert.Len(t, res.Splits, 2) {
+		assert.True(t, res.Splits[0].Updated)
+		assert.False(t, res.Splits[1].Updated)
+		assert.Contains(t, res.Splits[1].Skipped, "category: already set to 'Household'")
+	}
+}
+
+func TestNewTransactionWebHookUpdateError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "error"}`, http.StatusUnprocessableEntity)
+	}))
+	defer srv.Close()
+	wh := newTestHandler(t, srv, &config.Config{CategoriesEnabled: true})
+
+	rec := httptest.NewRecorder()
+	wh.HandleNewTransactionWebHook(rec, httptest.NewRequest(http.MethodPost, "/classify", strings.NewReader(splitWebHook)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var res ClassifyResponse
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	if assert.Len(t, res.Splits, 2) {
+		assert.False(t, res.Splits[0].Updated)
+		assert.NotEmpty(t, res.Splits[0].Error)
+		assert.Empty(t, res.Splits[1].Error)
+	}
+}
+
+func TestNewTransactionWebHookTransfer(t *testing.T) {
+	srv, updates := newFireflyServer(t)
+	wh := newTestHandler(t, srv, &config.Config{CategoriesEnabled: true, TrainingExcludedTypes: []string{"reconciliation"}})
+
+	for _, trnType := range []string{"transfer", "reconciliation"} {
+		payload := `{"content": {"id": 1, "transactions": [{"transaction_journal_id": "2", "description": "UBER TRIP", "type": "` + trnType + `"}]}}`
+		rec := httptest.NewRecorder()
+		wh.HandleNewTransactionWebHook(rec, httptest.NewRequest(http.MethodPost, "/classify", strings.NewReader(payload)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var res ClassifyResponse
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		if assert.Len(t, res.Splits, 1) {
+			assert.False(t, res.Splits[0].Updated)
+			assert.Equal(t, []string{"split: " + trnType + " is not classified"}, res.Splits[0].Skipped)
+		}
+	}
+	assert.Empty(t, *updates)
+}
+
+func TestNewTransactionWebHookSuggest(t *testing.T) {
+	srv, updates := newFireflyServer(t)
+	wh := newTestHandler(t, srv, &config.Config{CategoriesEnabled: true, SuggestMode: config.SuggestAlways, SuggestTag: config.DefaultSuggestTag})
+
+	payload := `{"content": {"id": 1, "transactions": [{"transaction_journal_id": "2", "description": "UBER TRIP", "notes": "paid by card\nffiiitc suggestions: Groceries 50%", "tags": ["work"]}]}}`
+	rec := httptest.NewRecorder()
+	wh.HandleNewTransactionWebHook(rec, httptest.NewRequest(http.MethodPost, "/classify", strings.NewReader(payload)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// category is left alone, previous suggestions are replaced
+	if assert.Len(t, *updates, 1) {
+		split := (*updates)[0].Transactions[0]
+		assert.Empty(t, split.Category)
+		assert.Regexp(t, `^paid by card\nffiiitc suggestions: Transport \d+%, Groceries \d+%$`, split.Notes)
+		assert.Equal(t, []string{"work", config.DefaultSuggestTag}, split.Tags)
+	}
+	var res ClassifyResponse
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	if assert.Len(t, res.Splits, 1) {
+		assert.True(t, res.Splits[0].Updated)
+		assert.Empty(t, res.Splits[0].Category)
+		assert.Equal(t, "Transport", res.Splits[0].Predicted)
+		assert.Len(t, res.Splits[0].Suggestions, 2)
+	}
+}
+
+func TestNewTransactionWebHookSuggestLowConfidence(t *testing.T) {
+	srv, updates := newFireflyServer(t)
+	wh := newTestHandler(t, srv, &config.Config{CategoriesEnabled: true, MinConfidence: 0.6, SuggestMode: config.SuggestLowConfidence})
+
+	// nothing is known about new shop, so it is only suggested
+	payload := `{"content": {"id": 1, "transactions": [
+		{"transaction_journal_id": "2", "description": "UBER TRIP"},
+		{"transaction_journal_id": "3", "description": "NEW SHOP"}
+	]}}`
+	rec := httptest.NewRecorder()
+	wh.HandleNewTransactionWebHook(rec, httptest.NewRequest(http.MethodPost, "/classify", strings.NewReader(payload)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	if assert.Len(t, *updates, 1) && assert.Len(t, (*updates)[0].Transactions, 2) {
+		confident, unknown := (*updates)[0].Transactions[0], (*updates)[0].Transactions[1]
+		assert.Equal(t, "Transport", confident.Category)
+		assert.Empty(t, confident.Notes)
+		assert.Empty(t, unknown.Category)
+		assert.True(t, strings.HasPrefix(unknown.Notes, suggestionsPrefix))
+		assert.Empty(t, unknown.Tags)
+	}
+}
+
+func TestSuggestionNotes(t *testing.T) {
+	suggestions := []classifier.CategoryScore{{Category: "Groceries", Probability: 0.824}, {Category: "Dining", Probability: 0.1}}
+	assert.Equal(t, "ffiiitc suggestions: Groceries 82%, Dining 10%", suggestionNotes("", suggestions))
+	assert.Equal(t, "receipt in drawer\nffiiitc suggestions: Groceries 82%, Dining 10%",
+		suggestionNotes("receipt in drawer\nffiiitc suggestions: Transport 60%", suggestions))
+}
+
+// send transaction to update web hook
+func learn(t *testing.T, wh *WebHookHandler, trn FireflyTrn) int {
+	payload, err := json.Marshal(FireflyWebHook{Content: FireFlyContent{Id: 1, Transactions: []FireflyTrn{trn}}})
+	assert.NoError(t, err)
+	rec := httptest.NewRecorder()
+	wh.HandleUpdateTransactionWebHook(rec, httptest.NewRequest(http.MethodPost, "/learn", strings.NewReader(string(payload))))
+	return rec.Code
+}
+
+// probability of category predicted for description
+func probability(wh *WebHookHandler, description, category string) float64 {
+	for _, score := range wh.Classifier().Predict(classifier.Transaction{Description: description}).Scores {
+		if score.Category == category {
+			return score.Probability
+		}
+	}
+	return 0
+}
+
+// categories remembered in learned state file by transaction journal id
+func savedCategories(t *testing.T, wh *WebHookHandler) map[string]string {
+	learned, err := loadLearnedCategories(wh.LearnedStateFile)
+	assert.NoError(t, err)
+	categories := make(map[string]string)
+	for id, l := range learned {
+		categories[id] = l.Category
+	}
+	return categories
+}
+
+func TestUpdateTransactionWebHookRepeated(t *testing.T) {
+	srv, _ := newFireflyServer(t)
+	wh := newTestHandler(t, srv, &config.Config{})
+
+	// firefly sends update for every change of transaction
+	trn := FireflyTrn{Id: "5", Description: "PETBARN", Category: "Pets", Tags: []string{}}
+	assert.Equal(t, http.StatusOK, learn(t, wh, trn))
+	learned := probability(wh, "PETBARN", "Pets")
+	assert.Equal(t, "Pets", wh.Classifier().Predict(classifier.Transaction{Description: "PETBARN"}).Category)
+	trn.Notes = "dog food"
+	assert.Equal(t, http.StatusOK, learn(t, wh, trn))
+	assert.Equal(t, learned, probability(wh, "PETBARN", "Pets"))
+
+	// learned category is forgotten when it changes, also after restart
+	wh.learned = nil
+	trn.Category = "Gifts"
+	assert.Equal(t, http.StatusOK, learn(t, wh, trn))
+	assert.Equal(t, "Gifts", wh.Classifier().Predict(classifier.Transaction{Description: "PETBARN"}).Category)
+	assert.Equal(t, map[string]string{"5": "Gifts"}, savedCategories(t, wh))
+
+	// model is trained from scratch on current categories
+	wh.resetLearnedCategories()
+	assert.NoFileExists(t, wh.LearnedStateFile)
+}
+
+func TestUpdateTransactionWebHook(t *testing.T) {
+	ruleSet, err := rules.Parse([]byte("rules:\n  - name: rideshare\n    description: UBER\n    category: Rideshare\n"))
+	assert.NoError(t, err)
+	tests := []struct {
+		name    string
+		trn     FireflyTrn
+		rules   *rules.RuleSet
+		learned bool
+	}{
+		{"empty category", FireflyTrn{Description: "PETBARN"}, nil, false},
+		{"transfer", FireflyTrn{Description: "PETBARN", Category: "Pets", Type: firefly.TypeTransfer}, nil, false},
+		{"ignored category", FireflyTrn{Description: "PETBARN", Category: "uncategorised"}, nil, false},
+		{"set by ffiiitc", FireflyTrn{Description: "UBER TRIP", Category: "Transport", Tags: []string{firefly.ClassifiedTag}}, nil, false},
+		{"corrected", FireflyTrn{Description: "UBER TRIP", Category: "Groceries", Tags: []string{firefly.ClassifiedTag}}, nil, true},
+		{"set by rule", FireflyTrn{Description: "UBER TRIP", Category: "Rideshare", Tags: []string{firefly.ClassifiedTag}}, ruleSet, false},
+		{"corrected rule", FireflyTrn{Description: "UBER TRIP", Category: "Transport", Tags: []string{firefly.ClassifiedTag}}, ruleSet, true},
+		{"already predicted", FireflyTrn{Description: "WOOLWORTHS METRO", Category: "Groceries"}, nil, false},
+		{"set by hand", FireflyTrn{Description: "PETBARN", Category: "Pets"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, updates := newFireflyServer(t)
+			wh := newTestHandler(t, srv, &config.Config{
+				TrainingExcludedTypes: config.DefaultExcludedTypes,
+				Validation:            classifier.ValidationOptions{IgnoredCategories: []string{"Uncategorised"}},
+			})
+			wh.Rules = tt.rules
+			tt.trn.Id = "5"
+
+			assert.Equal(t, http.StatusOK, learn(t, wh, tt.trn))
+			assert.Empty(t, *updates)
+			if !tt.learned {
+				assert.NoFileExists(t, wh.ModelFile)
+				assert.NoFileExists(t, wh.LearnedStateFile)
+				return
+			}
+			assert.FileExists(t, wh.ModelFile)
+			assert.Equal(t, map[string]string{"5": tt.trn.Category}, savedCategories(t, wh))
+		})
+	}
+}
+
+func TestUpdateTransactionWebHookErrors(t *testing.T) {
+	srv, _ := newFireflyServer(t)
+	wh := newTestHandler(t, srv, &config.Config{})
+	trn := FireflyTrn{Id: "5", Description: "PETBARN", Category: "Pets"}
+
+	rec := httptest.NewRecorder()
+	wh.HandleUpdateTransactionWebHook(rec, httptest.NewRequest(http.MethodGet, "/learn", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = httptest.NewRecorder()
+	wh.HandleUpdateTransactionWebHook(rec, httptest.NewRequest(http.MethodPost, "/learn", strings.NewReader("{")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// model can not be saved
+	wh.ModelFile = filepath.Join(t.TempDir(), "missing", "model.gob")
+	assert.Equal(t, http.StatusInternalServerError, learn(t, wh, trn))
+	assert.NoFileExists(t, wh.LearnedStateFile)
+
+	// backend without online learning
+	wh.SwapClassifier(struct{ classifier.Classifier }{wh.Classifier()})
+	assert.Equal(t, http.StatusNotImplemented, learn(t, wh, trn))
+}
+
+func TestPruneLearnedCategories(t *testing.T) {
+	now := time.Now()
+	learned := map[string]learnedCategory{
+		"1": {Category: "Groceries", Updated: now.Add(-time.Hour)},
+		"2": {Category: "Transport", Updated: now},
+		"3": {Category: "Pets", Updated: now.Add(-2 * time.Hour)},
+	}
+	pruneLearnedCategories(learned, 3)
+	assert.Len(t, learned, 3)
+
+	// the oldest ones are forgotten
+	pruneLearnedCategories(learned, 1)
+	assert.Equal(t, map[string]learnedCategory{"2": {Category: "Transport", Updated: now}}, learned)
+}
+
+// classifier recording categories relearned
+type relearner struct {
+	classifier.Classifier
+	relearned [][2]string // old and new category
+}
+
+func (r *relearner) Relearn(t classifier.Transaction, oldCategory, newCategory string) {
+	r.relearned = append(r.relearned, [2]string{oldCategory, newCategory})
+}
+
+func TestUpdateTransactionWebHookAppliedCategory(t *testing.T) {
+	srv, _ := newFireflyServer(t)
+	wh := newTestHandler(t, srv, &config.Config{CategoriesEnabled: true})
+	cls := &relearner{Classifier: wh.Classifier()}
+	wh.SwapClassifier(cls)
+
+	// category set by classification is remembered
+	payload := `{"content": {"id": 1, "transactions": [{"transaction_journal_id": "5", "description": "UBER TRIP"}]}}`
+	rec := httptest.NewRecorder()
+	wh.HandleNewTransactionWebHook(rec, httptest.NewRequest(http.MethodPost, "/classify", strings.NewReader(payload)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, map[string]string{"5": "Transport"}, savedCategories(t, wh))
+
+	// and forgotten once it is corrected
+	trn := FireflyTrn{Id: "5", Description: "UBER TRIP", Category: "Groceries", Tags: []string{firefly.ClassifiedTag}}
+	assert.Equal(t, http.StatusOK, learn(t, wh, trn))
+	// split tagged by ffiiitc before its category was remembered
+	trn = FireflyTrn{Id: "6", Description: "OPAL TOPUP", Category: "Groceries", Tags: []string{firefly.ClassifiedTag}}
+	assert.Equal(t, http.StatusOK, learn(t, wh, trn))
+	assert.Equal(t, [][2]string{{"Transport", "Groceries"}, {"", "Groceries"}}, cls.relearned)
+}
+
+// fake firefly server responding to transaction requests with given status and body
+func newTransactionsServer(t *testing.T, status int, body string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+const trainingTransactions = `{"data": [{"id": "1", "attributes": {"transactions": [
+	{"description": "WOOLWORTHS METRO", "category_name": "Groceries", "type": "withdrawal"},
+	{"description": "COLES SUPERMARKET", "category_name": "Groceries", "type": "withdrawal"},
+	{"description": "PETBARN", "category_name": "Pets", "type": "withdrawal"},
+	{"description": "PETSTOCK", "category_name": "Pets", "type": "withdrawal"}
+]}}], "meta": {"pagination": {"total_pages": 1}}}`
+
+func TestForceTrainingModel(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		modelFile string // relative to temp dir, default one if empty
+		swapped   bool
+	}{
+		{"trained", http.StatusOK, trainingTransactions, "", true},
+		{"fetch failed", http.StatusInternalServerError, `{"message": "Server Error"}`, "", false},
+		{"single category", http.StatusOK, `{"data": [{"id": "1", "attributes": {"transactions": [
+			{"description": "PETBARN", "category_name": "Pets", "type": "withdrawal"}
+		]}}], "meta": {"pagination": {"total_pages": 1}}}`, "", false},
+		{"save failed", http.StatusOK, trainingTransactions, "missing/model.gob", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := newTestHandler(t, newTransactionsServer(t, tt.status, tt.body), &config.Config{Backend: classifier.BackendMultinomial})
+			wh.FireflyClient.MaxRetries = 0
+			if tt.modelFile != "" {
+				wh.ModelFile = filepath.Join(t.TempDir(), tt.modelFile)
+			}
+			old := wh.Classifier()
+
+			rec := httptest.NewRecorder()
+			wh.HandleForceTrainingModel(rec, httptest.NewRequest(http.MethodPost, "/train", nil))
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			var job training.Job
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&job))
+			assert.Eventually(t, func() bool {
+				job, _ = wh.TrainingJobs.Get(job.Id)
+				return !job.Active()
+			}, 5*time.Second, 10*time.Millisecond)
+
+			if !tt.swapped {
+				assert.Equal(t, training.StateFailed, job.State)
+				assert.NotEmpty(t, job.Error)
+				assert.Same(t, old, wh.Classifier())
+				return
+			}
+			assert.Equal(t, training.StateSaved, job.State)
+			assert.NotSame(t, old, wh.Classifier())
+			assert.ElementsMatch(t, []string{"Groceries", "Pets"}, wh.Classifier().Describe().Categories)
+			assert.FileExists(t, wh.ModelFile)
+		})
+	}
+}
+
+func TestFireflyTrnTransaction(t *testing.T) {
+	var trn FireflyTrn
+	err := json.Unmarshal([]byte(`{"description": "UBER TRIP", "amount": "40.00", "date": "2024-01-31T10:15:00+11:00"}`), &trn)
+	assert.NoError(t, err)
+	assert.Equal(t, 40.0, trn.transaction().Amount)
+	assert.Equal(t, time.Date(2024, 1, 31, 10, 15, 0, 0, time.FixedZone("", 11*3600)).Unix(), trn.transaction().Date.Unix())
+
+	// date without time
+	trn.Date = "2024-01-31"
+	assert.Equal(t, time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), trn.transaction().Date)
+	trn.Date = "31/01/2024"
+	assert.True(t, trn.transaction().Date.IsZero())
+}
+
+func TestHandlePredict(t *testing.T) {
+	srv, updates := newFireflyServer(t)
+	wh := newTestHandler(t, srv, &config.Config{CategoriesEnabled: true, MinConfidence: 0.6})
+
+	predict := func(payload string) (int, PredictResponse) {
+		rec := httptest.NewRecorder()
+		wh.HandlePredict(rec, httptest.NewRequest(http.MethodPost, "/predict", strings.NewReader(payload)))
+		var res PredictResponse
+		if rec.Code == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		}
+		return rec.Code, res
+	}
+
+	code, res := predict(`{"description": "UBER TRIP"}`)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, res.Predictions, 1) {
+		assert.Equal(t, "UBER TRIP", res.Predictions[0].Description)
+		assert.Equal(t, "Transport", res.Predictions[0].Category)
+		assert.True(t, res.Predictions[0].Confident)
+	}
+
+	// nothing is known about new shop, so category would not be applied
+	code, res = predict(`{"descriptions": ["WOOLWORTHS METRO", "NEW SHOP"]}`)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, res.Predictions, 2) {
+		assert.Equal(t, "Groceries", res.Predictions[0].Category)
+		assert.True(t, res.Predictions[0].Confident)
+		assert.InDelta(t, 0.5, res.Predictions[1].Confidence, 0.000001)
+		assert.False(t, res.Predictions[1].Confident)
+	}
+
+	code, res = predict(`{"description": "OPAL TOPUP", "transactions": [{"description": "COLES SUPERMARKET", "amount": 40, "type": "withdrawal"}]}`)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, res.Predictions, 2) {
+		assert.Equal(t, "Transport", res.Predictions[0].Category)
+		assert.Equal(t, "COLES SUPERMARKET", res.Predictions[1].Description)
+		assert.Equal(t, "Groceries", res.Predictions[1].Category)
+	}
+
+	// transactions are sent the way firefly sends them to web hook
+	code, res = predict(`{"transactions": [{"description": "UBER TRIP", "amount": "40.00", "date": "2024-01-31", "currency_code": "AUD"}]}`)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, res.Predictions, 1) {
+		assert.Equal(t, "Transport", res.Predictions[0].Category)
+	}
+	code, _ = predict(`{"transactions": [{"description": "UBER TRIP", "amount": "forty"}]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = predict(`{}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = predict(`{"descriptions": `)
+	assert.Equal(t, http.StatusBadRequest, code)
+	rec := httptest.NewRecorder()
+	wh.HandlePredict(rec, httptest.NewRequest(http.MethodGet, "/predict", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// dry run never updates firefly
+	assert.Empty(t, *updates)
+}
+
+func TestNewTransactionWebHookMinConfidence(t *testing.T) {
+	// nothing is known about new shop, both categories are equally likely
+	payload := `{"content": {"id": 1, "transactions": [
+		{"transaction_journal_id": "2", "description": "NEW SHOP"},
+		{"transaction_journal_id": "3", "description": "NEW SHOP", "category_name": "Household"}
+	]}}`
+	classify := func(cfg *config.Config) (*[]firefly.FireFlyTransactions, ClassifyResponse) {
+		srv, updates := newFireflyServer(t)
+		wh := newTestHandler(t, srv, cfg)
+		rec := httptest.NewRecorder()
+		wh.HandleNewTransactionWebHook(rec, httptest.NewRequest(http.MethodPost, "/classify", strings.NewReader(payload)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var res ClassifyResponse
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		return updates, res
+	}
+
+	// category is left empty and nothing is updated
+	updates, res := classify(&config.Config{CategoriesEnabled: true, MinConfidence: 0.6})
+	assert.Empty(t, *updates)
+	if assert.Len(t, res.Splits, 2) {
+		assert.False(t, res.Splits[0].Updated)
+		assert.Empty(t, res.Splits[0].Category)
+		assert.Contains(t, res.Splits[0].Skipped, "category: confidence 0.50 below 0.60")
+	}
+
+	// only transaction without category is tagged for review
+	updates, res = classify(&config.Config{CategoriesEnabled: true, MinConfidence: 0.6, ReviewTag: "needs-review"})
+	if assert.Len(t, *updates, 1) && assert.Len(t, (*updates)[0].Transactions, 2) {
+		uncategorised, categorised := (*updates)[0].Transactions[0], (*updates)[0].Transactions[1]
+		assert.Empty(t, uncategorised.Category)
+		assert.Equal(t, []string{"needs-review"}, uncategorised.Tags)
+		assert.Empty(t, categorised.Category)
+		assert.Empty(t, categorised.Tags)
+	}
+	if assert.Len(t, res.Splits, 2) {
+		assert.True(t, res.Splits[0].Updated)
+		assert.False(t, res.Splits[1].Updated)
+	}
+}
+
+func TestHandleExplain(t *testing.T) {
+	srv, _ := newFireflyServer(t)
+	wh := newTestHandler(t, srv, &config.Config{})
+	dataSet := append(classifier.TransactionDataSet{
+		{Category: "Pets", Description: "PETBARN"},
+		{Category: "Utilities", Description: "AGL ENERGY"},
+	}, testDataSet...)
+	cls, err := classifier.NewClassifierWithTraining(classifier.BackendMultinomial, dataSet, classifier.FeatureOptions{}, wh.Logger)
+	assert.NoError(t, err)
+	wh.SwapClassifier(cls)
+
+	explain := func(payload string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		wh.HandleExplain(rec, httptest.NewRequest(http.MethodPost, "/explain", strings.NewReader(payload)))
+		return rec
+	}
+
+	rec := explain(`{"description": "UBER TRIP"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var explanation classifier.Explanation
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&explanation))
+	assert.Equal(t, "UBER TRIP", explanation.Description)
+	if assert.Len(t, explanation.Categories, defaultExplainTop) {
+		assert.Equal(t, "Transport", explanation.Categories[0].Category)
+	}
+	assert.NotEmpty(t, explanation.Contributions)
+
+	rec = explain(`{"description": "UBER TRIP", "top": 1}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&explanation))
+	assert.Len(t, explanation.Categories, 1)
+
+	rec = explain(`{"description": "UBER TRIP", "amount": "40.00", "date": "2024-01-31", "type": "withdrawal"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&explanation))
+	assert.Equal(t, "UBER TRIP", explanation.Description)
+
+	assert.Equal(t, http.StatusBadRequest, explain(`{"top": 2}`).Code)
+	assert.Equal(t, http.StatusBadRequest, explain(`{"description": `).Code)
+
+	// backend without explanation
+	wh.SwapClassifier(struct{ classifier.Classifier }{wh.Classifier()})
+	assert.Equal(t, http.StatusNotImplemented, explain(`{"description": "UBER TRIP"}`).Code)
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pkgz/lgr"
+	"golang.org/x/crypto/sha3"
+)
+
+// header firefly puts webhook signature into
+// format: t=<unix timestamp>,v1=<hex hmac sha3-256 of "timestamp.body">
+const signatureHeader = "Signature"
+
+var (
+	ErrSignatureMissing   = errors.New("signature header is missing")
+	ErrSignatureMalformed = errors.New("signature header is malformed")
+	ErrSignatureMismatch  = errors.New("signature does not match")
+	ErrSignatureExpired   = errors.New("signature timestamp is out of tolerance")
+	ErrSecretNotSet       = errors.New("webhook secret is not set")
+)
+
+// wraps webhook handler with firefly signature verification
+// requests without valid signature get 401
+// if secret is empty, all requests are rejected
+func VerifySignature(secret string, tolerance time.Duration, l *lgr.Logger, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if secret == "" {
+			l.Logf("WARN rejected webhook %s from %s: %v", r.URL.Path, r.RemoteAddr, ErrSecretNotSet)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			l.Logf("ERROR reading webhook payload: %v", err)
+			http.Error(w, "bad data", http.StatusBadRequest)
+			return
+		}
+		r.Body.Close()
+
+		err = checkSignature(r.Header.Get(signatureHeader), body, secret, tolerance, time.Now())
+		if err != nil {
+			l.Logf("WARN rejected webhook %s from %s: %v", r.URL.Path, r.RemoteAddr, err)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
+
+// check firefly signature header against payload
+func checkSignature(header string, body []byte, secret string, tolerance time.Duration, now time.Time) error {
+	if header == "" {
+		return ErrSignatureMissing
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return ErrSignatureMalformed
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return ErrSignatureMalformed
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSignatureMalformed
+	}
+	age := now.Sub(time.Unix(ts, 0))
+	if tolerance > 0 && (age > tolerance || age < -tolerance) {
+		return fmt.Errorf("%w: %v", ErrSignatureExpired, age.Round(time.Second))
+	}
+
+	expected := computeSignature(timestamp, body, secret)
+	for _, sig := range signatures {
+		decoded, err := hex.DecodeString(sig)
+		if err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return ErrSignatureMismatch
+}
+
+// hmac sha3-256 of "timestamp.body" as firefly does it
+func computeSignature(timestamp string, body []byte, secret string) []byte {
+	mac := hmac.New(sha3.New256, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
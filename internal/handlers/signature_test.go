@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/lgr"
+	"github.com/stretchr/testify/assert"
+)
+
+func signatureFor(ts time.Time, body, secret string) string {
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(computeSignature(timestamp, []byte(body), secret))
+}
+
+func TestCheckSignature(t *testing.T) {
+	now := time.Now()
+	body := `{"content":{"id":1}}`
+	secret := "secret"
+
+	tests := []struct {
+		name   string
+		header string
+		err    error
+	}{
+		{"Valid", signatureFor(now, body, secret), nil},
+		{"Missing", "", ErrSignatureMissing},
+		{"Malformed", "garbage", ErrSignatureMalformed},
+		{"NoSignature", "t=" + strconv.FormatInt(now.Unix(), 10), ErrSignatureMalformed},
+		{"WrongSecret", signatureFor(now, body, "other"), ErrSignatureMismatch},
+		{"TamperedBody", signatureFor(now, `{"content":{"id":2}}`, secret), ErrSignatureMismatch},
+		{"Expired", signatureFor(now.Add(-time.Hour), body, secret), ErrSignatureExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSignature(tt.header, []byte(body), secret, 5*time.Minute, now)
+			if tt.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	logger := lgr.New(lgr.Debug, lgr.CallerFunc)
+	body := `{"content":{"id":1}}`
+	next := func(w http.ResponseWriter, r *http.Request) {
+		received, _ := io.ReadAll(r.Body)
+		w.Write(received)
+	}
+	handler := VerifySignature("secret", 5*time.Minute, logger, next)
+
+	t.Run("SignedRequest", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/classify", strings.NewReader(body))
+		req.Header.Set(signatureHeader, signatureFor(time.Now(), body, "secret"))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, body, rec.Body.String())
+	})
+
+	t.Run("UnsignedRequest", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/classify", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("SecretNotSet", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/classify", strings.NewReader(body))
+		req.Header.Set(signatureHeader, signatureFor(time.Now(), body, ""))
+		rec := httptest.NewRecorder()
+		VerifySignature("", 5*time.Minute, logger, next)(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
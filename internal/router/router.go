@@ -1,13 +1,35 @@
 package router
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 )
 
+const shutdownTimeout = 10 * time.Second // time for requests in flight to finish on shutdown
+
+// credentials for administrative routes
+// bearer token, basic auth user and password or both can be set
+type AdminAuth struct {
+	Token    string
+	User     string
+	Password string
+	Disabled bool // admin routes are open, only if explicitly disabled
+}
+
+// returns true if any credentials are set
+func (a AdminAuth) Enabled() bool {
+	return a.Token != "" || a.User != ""
+}
+
 type Router struct {
-	Mux *http.ServeMux
+	Mux       *http.ServeMux
+	AdminAuth AdminAuth
 }
 
 func NewRouter() *Router {
@@ -20,6 +42,53 @@ func (r *Router) AddRoute(pattern string, handler func(w http.ResponseWriter, r
 	r.Mux.HandleFunc(pattern, handler)
 }
 
+// add route that requires admin credentials
+func (r *Router) AddAdminRoute(pattern string, handler func(w http.ResponseWriter, r *http.Request)) {
+	r.Mux.HandleFunc(pattern, r.requireAdmin(handler))
+}
+
+// middleware checking admin credentials
+// if no credentials configured, requests are rejected
+// unless admin auth is explicitly disabled
+func (r *Router) requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if r.AdminAuth.Disabled {
+			handler(w, req)
+			return
+		}
+		if !r.AdminAuth.Enabled() {
+			log.Printf("%s %s %s forbidden, admin credentials are not set\n", req.RemoteAddr, req.Method, req.URL)
+			http.Error(w, "admin credentials are not set", http.StatusForbidden)
+			return
+		}
+		if !r.AdminAuth.authorized(req) {
+			log.Printf("%s %s %s unauthorized\n", req.RemoteAddr, req.Method, req.URL)
+			w.Header().Set("WWW-Authenticate", `Basic realm="ffiiitc"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, req)
+	}
+}
+
+// check request credentials against bearer token or basic auth
+func (a AdminAuth) authorized(req *http.Request) bool {
+	header := req.Header.Get("Authorization")
+	if token, found := strings.CutPrefix(header, "Bearer "); found {
+		return a.Token != "" && secureCompare(token, a.Token)
+	}
+	if user, password, ok := req.BasicAuth(); ok {
+		return a.User != "" &&
+			secureCompare(user, a.User) &&
+			secureCompare(password, a.Password)
+	}
+	return false
+}
+
+func secureCompare(given, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
 func (r *Router) logRoute(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("%s %s %s\n", r.RemoteAddr, r.Method, r.URL)
@@ -27,7 +96,23 @@ func (r *Router) logRoute(handler http.Handler) http.Handler {
 	})
 }
 
-func (r *Router) Run(port int) error {
-	ps := fmt.Sprintf(":%d", port)
-	return http.ListenAndServe(ps, r.logRoute(r.Mux))
+// serve requests until context is cancelled,
+// then wait for requests in flight to finish
+func (r *Router) Run(ctx context.Context, port int) error {
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: r.logRoute(r.Mux),
+	}
+	shutdown := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		shutdown <- srv.Shutdown(shutdownCtx)
+	}()
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return <-shutdown
 }
@@ -1,10 +1,12 @@
 package router
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -39,3 +41,69 @@ func TestRouter(t *testing.T) {
 		assert.Equal(t, expectedBody, string(body))
 	})
 }
+
+func TestAdminRoute(t *testing.T) {
+
+	router := NewRouter()
+	router.AdminAuth = AdminAuth{Token: "token", User: "admin", Password: "password"}
+	router.AddAdminRoute("/admin", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := httptest.NewServer(router.Mux)
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		setup  func(req *http.Request)
+		status int
+	}{
+		{"NoCredentials", func(req *http.Request) {}, http.StatusUnauthorized},
+		{"ValidToken", func(req *http.Request) { req.Header.Set("Authorization", "Bearer token") }, http.StatusOK},
+		{"InvalidToken", func(req *http.Request) { req.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+		{"ValidBasicAuth", func(req *http.Request) { req.SetBasicAuth("admin", "password") }, http.StatusOK},
+		{"InvalidBasicAuth", func(req *http.Request) { req.SetBasicAuth("admin", "nope") }, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, server.URL+"/admin", nil)
+			assert.NoError(t, err)
+			tt.setup(req)
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+
+	t.Run("NoCredentialsSet", func(t *testing.T) {
+		closed := NewRouter()
+		closed.AddAdminRoute("/admin", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		rec := httptest.NewRecorder()
+		closed.Mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin", nil))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("AuthDisabled", func(t *testing.T) {
+		open := NewRouter()
+		open.AdminAuth = AdminAuth{Disabled: true}
+		open.AddAdminRoute("/admin", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		rec := httptest.NewRecorder()
+		open.Mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
+
+func TestRun(t *testing.T) {
+	router := NewRouter()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// server shuts down once context is done
+	assert.NoError(t, router.Run(ctx, 0))
+}
@@ -0,0 +1,142 @@
+package rules
+
+import (
+	"ffiiitc/internal/classifier"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// rule assigning category and/or tags to matching transactions
+// all set conditions have to match, empty ones are ignored
+type Rule struct {
+	Name        string   `yaml:"name" json:"name"`
+	Description string   `yaml:"description" json:"description,omitempty"` // regular expression
+	MinAmount   *float64 `yaml:"min_amount" json:"min_amount,omitempty"`
+	MaxAmount   *float64 `yaml:"max_amount" json:"max_amount,omitempty"`
+	Account     string   `yaml:"account" json:"account,omitempty"` // source or destination name or IBAN
+	Type        string   `yaml:"type" json:"type,omitempty"`
+	Currency    string   `yaml:"currency" json:"currency,omitempty"`
+	Category    string   `yaml:"category" json:"category,omitempty"`
+	Tags        []string `yaml:"tags" json:"tags,omitempty"`
+
+	description *regexp.Regexp
+}
+
+// ordered rules, first matching rule wins
+type RuleSet struct {
+	rules []*Rule
+}
+
+// rules file layout
+type rulesFile struct {
+	Rules []*Rule `yaml:"rules"`
+}
+
+// load rules from yaml or json file
+func LoadFile(name string) (*RuleSet, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// parse rules from yaml or json
+// json is parsed as yaml, which is its superset
+func Parse(data []byte) (*RuleSet, error) {
+	var file rulesFile
+	err := yaml.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("parsing rules: %w", err)
+	}
+	return NewRuleSet(file.Rules)
+}
+
+// validate rules and compile their patterns
+// rules without name are named by position
+func NewRuleSet(rules []*Rule) (*RuleSet, error) {
+	for i, r := range rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if r.Category == "" && len(r.Tags) == 0 {
+			return nil, fmt.Errorf("rule '%s' assigns neither category nor tags", r.Name)
+		}
+		if r.Description == "" && r.MinAmount == nil && r.MaxAmount == nil && r.Account == "" && r.Type == "" && r.Currency == "" {
+			return nil, fmt.Errorf("rule '%s' has no conditions", r.Name)
+		}
+		if r.MinAmount != nil && r.MaxAmount != nil && *r.MinAmount > *r.MaxAmount {
+			return nil, fmt.Errorf("rule '%s' min amount is greater than max amount", r.Name)
+		}
+		if r.Description != "" {
+			re, err := regexp.Compile(r.Description)
+			if err != nil {
+				return nil, fmt.Errorf("rule '%s' description: %w", r.Name, err)
+			}
+			r.description = re
+		}
+	}
+	return &RuleSet{rules: rules}, nil
+}
+
+// number of rules in set
+func (rs *RuleSet) Len() int {
+	if rs == nil {
+		return 0
+	}
+	return len(rs.rules)
+}
+
+// find first rule matching transaction
+// nil rule set has no rules
+func (rs *RuleSet) Match(t classifier.Transaction) (*Rule, bool) {
+	if rs == nil {
+		return nil, false
+	}
+	for _, r := range rs.rules {
+		if r.Matches(t) {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// check if all rule conditions match transaction
+// account, type and currency are compared ignoring case
+func (r *Rule) Matches(t classifier.Transaction) bool {
+	if r.description != nil && !r.description.MatchString(t.Description) {
+		return false
+	}
+	if r.MinAmount != nil && t.Amount < *r.MinAmount {
+		return false
+	}
+	if r.MaxAmount != nil && t.Amount > *r.MaxAmount {
+		return false
+	}
+	if r.Account != "" && !matchesAny(r.Account, t.SourceName, t.SourceIBAN, t.DestinationName, t.DestinationIBAN) {
+		return false
+	}
+	if r.Type != "" && !strings.EqualFold(r.Type, t.Type) {
+		return false
+	}
+	if r.Currency != "" && !strings.EqualFold(r.Currency, t.Currency) {
+		return false
+	}
+	return true
+}
+
+// check if value equals any of candidates ignoring case and spaces
+// ibans are often written with spaces between groups of digits
+func matchesAny(value string, candidates ...string) bool {
+	value = strings.ReplaceAll(value, " ", "")
+	for _, c := range candidates {
+		if c != "" && strings.EqualFold(value, strings.ReplaceAll(c, " ", "")) {
+			return true
+		}
+	}
+	return false
+}
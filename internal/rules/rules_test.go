@@ -0,0 +1,87 @@
+package rules
+
+import (
+	"ffiiitc/internal/classifier"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testRules = `
+rules:
+  - name: rent
+    account: "DE89 3704 0044 0532 0130 00"
+    type: withdrawal
+    category: Rent
+  - name: salary
+    description: "(?i)acme corp.*salary"
+    min_amount: 1000
+    category: Salary
+    tags: [income]
+  - description: "(?i)netflix|spotify"
+    max_amount: 30
+    currency: eur
+    tags: [subscription]
+`
+
+func TestMatch(t *testing.T) {
+	rs, err := Parse([]byte(testRules))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, rs.Len())
+
+	tests := []struct {
+		trn  classifier.Transaction
+		rule string
+	}{
+		{classifier.Transaction{Description: "RENT MAY", Type: "withdrawal", DestinationIBAN: "DE89370400440532013000"}, "rent"},
+		{classifier.Transaction{Description: "RENT MAY", Type: "deposit", DestinationIBAN: "DE89370400440532013000"}, ""},
+		{classifier.Transaction{Description: "ACME CORP MAY SALARY", Amount: 4200}, "salary"},
+		{classifier.Transaction{Description: "ACME CORP MAY SALARY", Amount: 10}, ""},
+		{classifier.Transaction{Description: "NETFLIX.COM", Amount: 15.99, Currency: "EUR"}, "rule 3"},
+		{classifier.Transaction{Description: "NETFLIX.COM", Amount: 15.99, Currency: "USD"}, ""},
+		{classifier.Transaction{Description: "WOOLWORTHS"}, ""},
+	}
+	for _, tt := range tests {
+		rule, ok := rs.Match(tt.trn)
+		if tt.rule == "" {
+			assert.False(t, ok, tt.trn.Description)
+			continue
+		}
+		if assert.True(t, ok, tt.trn.Description) {
+			assert.Equal(t, tt.rule, rule.Name)
+		}
+	}
+
+	// nil rule set never matches
+	var none *RuleSet
+	_, ok := none.Match(classifier.Transaction{Description: "RENT"})
+	assert.False(t, ok)
+	assert.Equal(t, 0, none.Len())
+}
+
+func TestLoadFileJSON(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "rules.json")
+	err := os.WriteFile(name, []byte(`{"rules": [{"name": "rent", "description": "RENT", "category": "Rent"}]}`), 0644)
+	assert.NoError(t, err)
+
+	rs, err := LoadFile(name)
+	assert.NoError(t, err)
+	rule, ok := rs.Match(classifier.Transaction{Description: "RENT MAY"})
+	assert.True(t, ok)
+	assert.Equal(t, "Rent", rule.Category)
+}
+
+func TestParseInvalidRules(t *testing.T) {
+	for _, data := range []string{
+		`rules: [{description: "RENT"}]`,
+		`rules: [{category: Rent}]`,
+		`rules: [{description: "(", category: Rent}]`,
+		`rules: [{min_amount: 10, max_amount: 5, category: Rent}]`,
+		`rules: {`,
+	} {
+		_, err := Parse([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
@@ -0,0 +1,181 @@
+package training
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"ffiiitc/internal/classifier"
+	"sync"
+	"time"
+)
+
+// training job states
+type State string
+
+const (
+	StateQueued   State = "queued"
+	StateFetching State = "fetching"
+	StateTraining State = "training"
+	StateSaved    State = "saved"
+	StateFailed   State = "failed"
+	maxJobHistory       = 20 // number of finished jobs to keep status for
+)
+
+var ErrJobRunning = errors.New("training job is already running")
+
+// training job status
+type Job struct {
+	Id           string                     `json:"id"`
+	State        State                      `json:"state"`
+	Start        string                     `json:"start,omitempty"`
+	End          string                     `json:"end,omitempty"`
+	Page         int                        `json:"page"`
+	TotalPages   int                        `json:"total_pages"`
+	Transactions int                        `json:"transactions"`
+	Categories   int                        `json:"categories"`
+	DataSet      *classifier.DataSetSummary `json:"data_set,omitempty"` // what was kept and discarded for training
+	Error        string                     `json:"error,omitempty"`
+	CreatedAt    time.Time                  `json:"created_at"`
+	FinishedAt   *time.Time                 `json:"finished_at,omitempty"`
+}
+
+// returns true if job is not finished yet
+func (j Job) Active() bool {
+	return j.State != StateSaved && j.State != StateFailed
+}
+
+// function performing training
+// it reports job progress via provided Progress
+type RunFunc func(p *Progress) error
+
+// keeps track of training jobs
+// only one job can be running at a time
+type Manager struct {
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	history []string // job ids in order of creation
+	running string   // id of running job, empty if none
+}
+
+// progress reporter for a single job
+type Progress struct {
+	manager *Manager
+	id      string
+}
+
+func NewManager() *Manager {
+	return &Manager{
+		jobs: make(map[string]*Job),
+	}
+}
+
+// start new training job in background
+// returns running job and ErrJobRunning if there is job in progress already
+func (m *Manager) Start(start, end string, run RunFunc) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.running != "" {
+		return *m.jobs[m.running], ErrJobRunning
+	}
+
+	job := &Job{
+		Id:        newJobId(),
+		State:     StateQueued,
+		Start:     start,
+		End:       end,
+		CreatedAt: time.Now(),
+	}
+	m.jobs[job.Id] = job
+	m.history = append(m.history, job.Id)
+	m.running = job.Id
+	m.trimHistory()
+
+	go m.run(job.Id, run)
+	return *job, nil
+}
+
+// get job status by id
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, exist := m.jobs[id]
+	if !exist {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+func (m *Manager) run(id string, run RunFunc) {
+	err := run(&Progress{manager: m, id: id})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job := m.jobs[id]
+	now := time.Now()
+	job.FinishedAt = &now
+	if err != nil {
+		job.State = StateFailed
+		job.Error = err.Error()
+	} else {
+		job.State = StateSaved
+	}
+	m.running = ""
+}
+
+// drop oldest finished jobs above history limit
+func (m *Manager) trimHistory() {
+	for len(m.history) > maxJobHistory {
+		oldest := m.history[0]
+		if m.jobs[oldest].Active() {
+			return
+		}
+		delete(m.jobs, oldest)
+		m.history = m.history[1:]
+	}
+}
+
+func (p *Progress) update(fn func(job *Job)) {
+	p.manager.mu.Lock()
+	defer p.manager.mu.Unlock()
+	fn(p.manager.jobs[p.id])
+}
+
+// set job state
+func (p *Progress) SetState(state State) {
+	p.update(func(job *Job) {
+		job.State = state
+	})
+}
+
+// set page of transactions being fetched
+func (p *Progress) SetPage(page, totalPages int) {
+	p.update(func(job *Job) {
+		job.Page = page
+		job.TotalPages = totalPages
+	})
+}
+
+// set number of transactions and categories used for training
+func (p *Progress) SetCounts(transactions, categories int) {
+	p.update(func(job *Job) {
+		job.Transactions = transactions
+		job.Categories = categories
+	})
+}
+
+// set summary of training data set validation
+func (p *Progress) SetDataSet(summary classifier.DataSetSummary) {
+	p.update(func(job *Job) {
+		job.DataSet = &summary
+	})
+}
+
+func newJobId() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,86 @@
+package training
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitForJob(t *testing.T, m *Manager, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		job, ok := m.Get(id)
+		assert.True(t, ok)
+		if !job.Active() {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
+
+func TestManager(t *testing.T) {
+
+	t.Run("SuccessfulJob", func(t *testing.T) {
+		m := NewManager()
+		job, err := m.Start("2024-01-01", "", func(p *Progress) error {
+			p.SetState(StateFetching)
+			p.SetPage(2, 2)
+			p.SetState(StateTraining)
+			p.SetCounts(10, 3)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, StateQueued, job.State)
+
+		job = waitForJob(t, m, job.Id)
+		assert.Equal(t, StateSaved, job.State)
+		assert.Equal(t, 2, job.Page)
+		assert.Equal(t, 2, job.TotalPages)
+		assert.Equal(t, 10, job.Transactions)
+		assert.Equal(t, 3, job.Categories)
+		assert.Equal(t, "2024-01-01", job.Start)
+		assert.NotNil(t, job.FinishedAt)
+	})
+
+	t.Run("FailedJob", func(t *testing.T) {
+		m := NewManager()
+		job, err := m.Start("", "", func(p *Progress) error {
+			return errors.New("firefly is down")
+		})
+		assert.NoError(t, err)
+
+		job = waitForJob(t, m, job.Id)
+		assert.Equal(t, StateFailed, job.State)
+		assert.Equal(t, "firefly is down", job.Error)
+	})
+
+	t.Run("RejectSecondJob", func(t *testing.T) {
+		m := NewManager()
+		release := make(chan struct{})
+		first, err := m.Start("", "", func(p *Progress) error {
+			<-release
+			return nil
+		})
+		assert.NoError(t, err)
+
+		second, err := m.Start("", "", func(p *Progress) error { return nil })
+		assert.ErrorIs(t, err, ErrJobRunning)
+		assert.Equal(t, first.Id, second.Id)
+
+		close(release)
+		waitForJob(t, m, first.Id)
+		_, err = m.Start("", "", func(p *Progress) error { return nil })
+		assert.NoError(t, err)
+	})
+
+	t.Run("UnknownJob", func(t *testing.T) {
+		m := NewManager()
+		_, ok := m.Get("nope")
+		assert.False(t, ok)
+	})
+}
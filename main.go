@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"ffiiitc/internal/classifier"
 	"ffiiitc/internal/config"
 	"ffiiitc/internal/firefly"
 	"ffiiitc/internal/handlers"
 	"ffiiitc/internal/router"
+	"ffiiitc/internal/rules"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
 
 	"github.com/go-pkgz/lgr"
 )
@@ -14,6 +21,21 @@ func main() {
 
 	// make logger
 	l := lgr.New(lgr.Debug, lgr.CallerFunc)
+
+	// run subcommand if given
+	// logs go to stderr to keep report on stdout
+	subcommands := map[string]func(args []string, l *lgr.Logger) error{
+		"evaluate": runEvaluate,
+		"backfill": runBackfill,
+	}
+	if len(os.Args) > 1 && subcommands[os.Args[1]] != nil {
+		err := subcommands[os.Args[1]](os.Args[2:], lgr.New(lgr.Debug, lgr.CallerFunc, lgr.Out(os.Stderr)))
+		if err != nil {
+			l.Logf("FATAL %s: %v", os.Args[1], err)
+		}
+		return
+	}
+
 	l.Logf("INFO Firefly transaction classification started")
 
 	// get the config
@@ -25,86 +47,178 @@ func main() {
 
 	// make firefly http client for rest api
 	fc := firefly.NewFireFlyHttpClient(cfg.FFApp, cfg.APIKey, config.FireflyAppTimeout, l)
+	fc.ExcludedTypes = cfg.TrainingExcludedTypes
+
+	// transactions are fetched only once and only
+	// if any of the models has to be trained
+	var trnDataset classifier.TransactionDataSet
+	getDataset := func() classifier.TransactionDataSet {
+		if trnDataset != nil {
+			return trnDataset
+		}
+		// get transactions in data set
+		//[ {cat, trn description, amount...}, {cat, trn description, amount...}... ]
+		// Empty string for start and end date means all transactions
+		trnDataset, err = fc.GetTransactionsDataset("", "")
+		l.Logf("DEBUG data set:\n %v", trnDataset)
+
+		if err != nil {
+			l.Logf("FATAL: unable to get list of transactions %v", err)
+		}
+		return trnDataset
+	}
 
 	// make classifier
 	// on first run, classifier will take all your
 	// transactions and learn their categories
 	// subsequent start classifier will load trained model from file
 	l.Logf("INFO loading classifier from model: %s", config.ModelFile)
-	cls, err := classifier.NewTrnClassifierFromFile(config.ModelFile, l)
+	cls, err := classifier.NewClassifierFromFile(config.ModelFile, l)
 	if err != nil {
 		l.Logf("ERROR %v", err)
 		l.Logf("INFO looks like we need to do some training...")
-		// get transactions in data set
-		//[ [cat, trn description], [cat, trn description]... ]
-		// Empty string for start and end date means all transactions
-		trnDataset, err := fc.GetTransactionsDataset("", "")
-		l.Logf("DEBUG data set:\n %v", trnDataset)
-
-		if err != nil {
-			l.Logf("FATAL: unable to get list of transactions %v", err)
-		}
+		trnDataset := getDataset()
 
 		// byesian package requires at least 2 transactions with different categories to start training
-
-		// we fail if no transactions in Firefly
-		if len(trnDataset) == 0 {
-			l.Logf("FATAL: no transactions in Firefly. At least 2 manually categorised transactions with different categories are required %v", trnDataset)
-		}
-
-		// we also check for at least 2 different categories available if transactions exist
-		categories := make(map[string]int)
-		for i, data := range trnDataset {
-			if len(data) == 0 {
-				l.Logf("WARN skipping empty transaction data at index %d", i)
-				continue
-			}
-			category := data[0]
-			if category == "" {
-				l.Logf("WARN skipping transaction with empty category at index %d", i)
-				continue
-			}
-			categories[category]++
-		}
-
-		l.Logf("INFO found %d different categories: %v", len(categories), categories)
-
-		if len(categories) < 2 {
-			l.Logf("FATAL: classifier needs at least 2 different categories in transactions, got %d. Categories found: %v",
-				len(categories),
-				categories)
+		// we fail if there are not enough categorised transactions in Firefly
+		categorised, summary, err := classifier.ValidateDataSet(trnDataset, cfg.Validation)
+		if err != nil {
+			l.Logf("FATAL: %v", err)
 			return
 		}
+		l.Logf("INFO data set: %s", summary)
+		l.Logf("DEBUG categories: %v", summary.Categories)
 
-		cls, err = classifier.NewTrnClassifierWithTraining(trnDataset, l)
+		cls, err = classifier.NewClassifierWithTraining(cfg.Backend, categorised, cfg.Features, l)
 		if err != nil {
 			l.Logf("FATAL: %v", err)
 		}
 		l.Logf("INFO training completed...")
-		err = cls.SaveClassifierToFile(config.ModelFile)
+		err = cls.Save(config.ModelFile)
 		if err != nil {
 			l.Logf("FATAL: %v", err)
 		}
 		l.Logf("INFO classifier saved to: %s", config.ModelFile)
 	}
 
-	l.Logf("DEBUG learned classes: %v", cls.Classifier.Classes)
+	info := cls.Describe()
+	l.Logf("INFO classifier backend: %s, features learned: %d", info.Backend, info.FeatureCount)
+	l.Logf("DEBUG learned classes: %v", info.Categories)
+	if info.Backend != cfg.Backend {
+		l.Logf("WARN model was trained with '%s' backend, retrain model to use '%s'", info.Backend, cfg.Backend)
+	}
+	if !reflect.DeepEqual(info.Features, cfg.Features) {
+		l.Logf("WARN model was trained with different feature options %+v, retrain model to apply %+v", info.Features, cfg.Features)
+	}
+
+	// make tag classifier the same way, but do not fail
+	// if there are no tags to learn from yet
+	var tagCls *classifier.TagClassifier
+	if cfg.TagsEnabled {
+		l.Logf("INFO loading tag classifier from model: %s", config.TagModelFile)
+		tagCls, err = classifier.NewTagClassifierFromFile(config.TagModelFile, l)
+		if err != nil {
+			l.Logf("INFO training tag classifier: %v", err)
+			tagCls, err = classifier.NewTagClassifierWithTraining(getDataset(), cfg.IgnoredTags(), cfg.Features, l)
+			if err == nil {
+				err = tagCls.Save(config.TagModelFile)
+			}
+			if err != nil {
+				l.Logf("WARN tag prediction is disabled until model is retrained: %v", err)
+				tagCls = nil
+			}
+		}
+	}
+	if tagCls != nil {
+		l.Logf("INFO learned tags: %v", tagCls.Tags())
+	}
+
+	// and budget classifier
+	var budgetCls classifier.Classifier
+	if cfg.BudgetsEnabled {
+		l.Logf("INFO loading budget classifier from model: %s", config.BudgetModelFile)
+		budgetCls, err = classifier.NewClassifierFromFile(config.BudgetModelFile, l)
+		if err != nil {
+			l.Logf("INFO training budget classifier: %v", err)
+			budgetCls, err = classifier.NewClassifierWithTraining(cfg.Backend, classifier.BudgetDataSet(getDataset()), cfg.Features, l)
+			if err == nil {
+				err = budgetCls.Save(config.BudgetModelFile)
+			}
+			if err != nil {
+				l.Logf("WARN budget prediction is disabled until model is retrained: %v", err)
+				budgetCls = nil
+			}
+		}
+	}
+	if budgetCls != nil {
+		l.Logf("INFO learned budgets: %v", budgetCls.Describe().Categories)
+	}
 
 	// init handlers
-	h := handlers.NewWebHookHandler(cls, fc, l)
+	h := handlers.NewWebHookHandler(cls, fc, cfg, l)
+	if tagCls != nil {
+		h.SwapTagClassifier(tagCls)
+	}
+	if budgetCls != nil {
+		h.SwapBudgetClassifier(budgetCls)
+	}
+	if cfg.RulesPath != "" {
+		h.Rules, err = rules.LoadFile(cfg.RulesPath)
+		if err != nil {
+			l.Logf("FATAL loading rules: %v", err)
+		}
+		l.Logf("INFO loaded %d rules from: %s", h.Rules.Len(), cfg.RulesPath)
+	}
 
 	// init router
 	r := router.NewRouter()
+	r.AdminAuth = router.AdminAuth{
+		Token:    cfg.AdminToken,
+		User:     cfg.AdminUser,
+		Password: cfg.AdminPassword,
+		Disabled: cfg.AdminAuthDisabled,
+	}
+	switch {
+	case r.AdminAuth.Disabled:
+		l.Logf("WARN admin auth is disabled, admin endpoints are not protected")
+	case !r.AdminAuth.Enabled():
+		l.Logf("WARN admin credentials are not set, admin endpoints reject all requests")
+	}
 
 	// add handlers
-	r.AddRoute("/classify", h.HandleNewTransactionWebHook)
-	r.AddRoute("/train", h.HandleForceTrainingModel)
-	// temporary remove this handle
-	//r.AddRoute("/learn", h.HandleUpdateTransactionWebHook)
+	classifyHook, learnHook := h.HandleNewTransactionWebHook, h.HandleUpdateTransactionWebHook
+	if cfg.WebhookSignatureDisabled {
+		l.Logf("WARN webhook signature check is disabled, /classify and /learn accept unsigned requests")
+	} else {
+		if cfg.WebhookSecret == "" {
+			l.Logf("WARN webhook secret is not set, /classify rejects all requests")
+		}
+		if cfg.LearnWebhookSecret == "" {
+			l.Logf("WARN learn webhook secret is not set, /learn rejects all requests")
+		}
+		tolerance := time.Duration(cfg.WebhookTolerance) * time.Second
+		classifyHook = handlers.VerifySignature(cfg.WebhookSecret, tolerance, l, classifyHook)
+		learnHook = handlers.VerifySignature(cfg.LearnWebhookSecret, tolerance, l, learnHook)
+	}
+	r.AddRoute("/classify", classifyHook)
+	r.AddAdminRoute("/train", h.HandleForceTrainingModel)
+	r.AddAdminRoute("/train/", h.HandleTrainingStatus)
+	r.AddRoute("/learn", learnHook)
+	r.AddAdminRoute("/explain", h.HandleExplain)
+	r.AddAdminRoute("/predict", h.HandlePredict)
+	r.AddAdminRoute("/model", h.HandleModelInfo)
+	r.AddAdminRoute("/backfill", h.HandleBackfill)
 
 	//run
-	err = r.Run(8080)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = r.Run(ctx, 8080)
+	// running backfill continues on next start from saved progress
+	if progress, ok := h.StopBackfill(); ok {
+		l.Logf("INFO backfill: %s after page %d of %d", progress.State, progress.Page, progress.TotalPages)
+	}
 	if err != nil {
 		panic(err)
 	}
+	l.Logf("INFO shut down")
 }